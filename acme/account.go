@@ -0,0 +1,161 @@
+package acme
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/db"
+)
+
+type accountRequest struct {
+	Contact              []string `json:"contact"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+	OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	Status               string   `json:"status"`
+}
+
+func (s *Server) accountObject(c *gin.Context, account *db.ACMEAccount) gin.H {
+	contact := account.Contact
+	if contact == nil {
+		contact = []string{}
+	}
+
+	return gin.H{
+		"status":  account.Status,
+		"contact": contact,
+		"orders":  s.url(c, "/account/%d/orders", account.ID),
+	}
+}
+
+func (s *Server) newAccount(c *gin.Context) {
+	req, account, err := s.verify(c, keyByJWK)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var payload accountRequest
+	if _, err = decodePayload(req, &payload); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if account != nil {
+		c.Header("Location", s.url(c, "/account/%d", account.ID))
+		s.respond(c, http.StatusOK, s.accountObject(c, account))
+		return
+	}
+
+	if payload.OnlyReturnExisting {
+		s.fail(c, newProblem(errAccountDoesNotExist, http.StatusBadRequest, "no account exists for this key"))
+		return
+	}
+
+	key, err := encodeJWK(req.key)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	keyThumbprint, err := thumbprint(req.key)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	account = &db.ACMEAccount{
+		Thumbprint: keyThumbprint,
+		Key:        string(key),
+		Contact:    payload.Contact,
+		Status:     db.ACMEValid,
+	}
+
+	if err = s.authority.DB.Create(account).Error; err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"account": account.ID,
+		"contact": account.Contact,
+	}).Info("created acme account")
+
+	c.Header("Location", s.url(c, "/account/%d", account.ID))
+	s.respond(c, http.StatusCreated, s.accountObject(c, account))
+}
+
+// account returns, updates the contacts of, or deactivates an account
+func (s *Server) account(c *gin.Context) {
+	req, account, err := s.verify(c, keyByKid)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if c.Param("id") != s.accountID(account) {
+		s.fail(c, unauthorized("requests can only be made for the account signing them"))
+		return
+	}
+
+	var payload accountRequest
+	update, err := decodePayload(req, &payload)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if update {
+		switch payload.Status {
+		case "":
+		case db.ACMEDeactivated:
+			account.Status = db.ACMEDeactivated
+		default:
+			s.fail(c, malformed("accounts can only be deactivated"))
+			return
+		}
+
+		if payload.Contact != nil {
+			account.Contact = payload.Contact
+		}
+
+		if err = s.authority.DB.Save(account).Error; err != nil {
+			s.fail(c, err)
+			return
+		}
+	}
+
+	s.respond(c, http.StatusOK, s.accountObject(c, account))
+}
+
+func (s *Server) accountOrders(c *gin.Context) {
+	_, account, err := s.verify(c, keyByKid)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if c.Param("id") != s.accountID(account) {
+		s.fail(c, unauthorized("requests can only be made for the account signing them"))
+		return
+	}
+
+	ids, err := db.ACMEAccountOrders(s.authority.DB, account.ID)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	orders := make([]string, len(ids))
+	for i, id := range ids {
+		orders[i] = s.url(c, "/order/%d", id)
+	}
+
+	s.respond(c, http.StatusOK, gin.H{"orders": orders})
+}
+
+// accountID returns the id of account as it appears in its URL
+func (s *Server) accountID(account *db.ACMEAccount) string {
+	return strconv.FormatInt(account.ID, 10)
+}
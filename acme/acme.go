@@ -0,0 +1,280 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/db"
+	"gorm.io/gorm"
+)
+
+// how a request has to identify its key
+const (
+	// keyByJWK requests carry their key, like new-account
+	keyByJWK = iota
+
+	// keyByKid requests name the account they're signed by
+	keyByKid
+
+	// keyByEither requests may do both, like revoke-cert
+	keyByEither
+)
+
+// Server serves the ACME API of a certificate authority, as in RFC 8555
+type Server struct {
+	authority *certs.Authority
+	nonces    *nonceStore
+	validator *validator
+
+	// prefix is the path the API is served under, e.g. /acme
+	prefix string
+}
+
+func New(a *certs.Authority) *Server {
+	return &Server{
+		authority: a,
+		nonces:    newNonceStore(),
+		validator: newValidator(a.Config()),
+	}
+}
+
+// Routes adds the ACME API to r under prefix
+func (s *Server) Routes(r *gin.Engine, prefix string) {
+	s.prefix = prefix
+
+	group := r.Group(prefix, s.headers)
+	group.GET("/directory", s.directory)
+	group.HEAD("/new-nonce", s.newNonce)
+	group.GET("/new-nonce", s.newNonce)
+
+	group.POST("/new-account", s.newAccount)
+	group.POST("/account/:id", s.account)
+	group.POST("/account/:id/orders", s.accountOrders)
+
+	group.POST("/new-order", s.newOrder)
+	group.POST("/order/:id", s.order)
+	group.POST("/order/:id/finalize", s.finalize)
+	group.POST("/authz/:id", s.authorization)
+	group.POST("/chall/:id", s.challenge)
+	group.POST("/cert/:id", s.certificate)
+	group.POST("/revoke-cert", s.revokeCert)
+}
+
+// headers adds a fresh nonce and a link to the directory to every response
+func (s *Server) headers(c *gin.Context) {
+	c.Header("Replay-Nonce", s.nonces.New())
+	c.Header("Cache-Control", "no-store")
+	c.Header("Link", fmt.Sprintf("<%s>;rel=\"index\"", s.url(c, "/directory")))
+	c.Next()
+}
+
+// origin returns the scheme and host clients reach the server at, from
+// acme.base_url or the request
+func (s *Server) origin(c *gin.Context) string {
+	if base := s.authority.Config().GetString("acme.base_url"); len(base) > 0 {
+		return strings.TrimSuffix(base, "/")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + c.Request.Host
+}
+
+// url returns the absolute URL of path in the API
+func (s *Server) url(c *gin.Context, path string, args ...interface{}) string {
+	return s.origin(c) + s.prefix + fmt.Sprintf(path, args...)
+}
+
+func (s *Server) respond(c *gin.Context, status int, body interface{}) {
+	c.JSON(status, body)
+}
+
+// fail responds with a problem document, hiding anything that isn't one
+func (s *Server) fail(c *gin.Context, err error) {
+	var p *problem
+	if !errors.As(err, &p) {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  c.Request.URL.Path,
+		}).Error("acme request failed")
+
+		p = serverInternal()
+	}
+
+	body, _ := json.Marshal(p)
+	c.Data(p.Status, "application/problem+json", body)
+}
+
+func (s *Server) directory(c *gin.Context) {
+	s.respond(c, http.StatusOK, gin.H{
+		"newNonce":   s.url(c, "/new-nonce"),
+		"newAccount": s.url(c, "/new-account"),
+		"newOrder":   s.url(c, "/new-order"),
+		"revokeCert": s.url(c, "/revoke-cert"),
+		"meta": gin.H{
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (s *Server) newNonce(c *gin.Context) {
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// verify checks the JWS in the request body and returns it along with the
+// account that signed it. The account is nil for requests signed with a key
+// that has no account.
+func (s *Server) verify(c *gin.Context, keyMode int) (*jwsRequest, *db.ACMEAccount, error) {
+	if !strings.HasPrefix(c.ContentType(), "application/jose+json") {
+		return nil, nil, newProblem(errMalformed, http.StatusUnsupportedMediaType, "requests have to be application/jose+json")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		return nil, nil, malformed("unable to read request")
+	}
+
+	msg, header, payload, err := parseJWS(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !s.nonces.Use(header.Nonce) {
+		return nil, nil, newProblem(errBadNonce, http.StatusBadRequest, "invalid or reused nonce")
+	}
+
+	if header.URL != s.origin(c)+c.Request.URL.Path {
+		return nil, nil, unauthorized("url %q in the protected header doesn't match the request", header.URL)
+	}
+
+	hasJWK, hasKid := len(header.JWK) > 0, len(header.Kid) > 0
+	if hasJWK == hasKid {
+		return nil, nil, malformed("exactly one of jwk and kid has to be given")
+	}
+
+	if hasJWK && keyMode == keyByKid {
+		return nil, nil, malformed("this request has to name its account with kid")
+	}
+
+	if hasKid && keyMode == keyByJWK {
+		return nil, nil, malformed("this request has to carry its key in jwk")
+	}
+
+	var key crypto.PublicKey
+	var account *db.ACMEAccount
+	if hasJWK {
+		key, err = parseJWK(header.JWK)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keyThumbprint, err := thumbprint(key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		account, err = db.ACMEAccountByThumbprint(s.authority.DB, keyThumbprint)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		account, err = s.loadAccount(c, header.Kid)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, err = parseJWK([]byte(account.Key))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	signature, err := unb64(msg.Signature)
+	if err != nil {
+		return nil, nil, malformed("invalid signature encoding")
+	}
+
+	if err = verifySignature(header.Alg, key, []byte(msg.Protected+"."+msg.Payload), signature); err != nil {
+		return nil, nil, err
+	}
+
+	return &jwsRequest{header: *header, payload: payload, key: key}, account, nil
+}
+
+// loadAccount returns the valid account named by kid, its account URL
+func (s *Server) loadAccount(c *gin.Context, kid string) (*db.ACMEAccount, error) {
+	prefix := s.url(c, "/account/")
+	id, err := strconv.ParseInt(strings.TrimPrefix(kid, prefix), 10, 64)
+	if !strings.HasPrefix(kid, prefix) || err != nil {
+		return nil, newProblem(errAccountDoesNotExist, http.StatusBadRequest, "unknown account %q", kid)
+	}
+
+	account := &db.ACMEAccount{}
+	if err = s.authority.DB.First(account, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newProblem(errAccountDoesNotExist, http.StatusBadRequest, "unknown account %q", kid)
+		}
+
+		return nil, err
+	}
+
+	if account.Status != db.ACMEValid {
+		return nil, unauthorized("account is %s", account.Status)
+	}
+
+	return account, nil
+}
+
+// decodePayload unmarshals the request's payload into v. POST-as-GET
+// requests have an empty payload, which is reported as false.
+func decodePayload(req *jwsRequest, v interface{}) (bool, error) {
+	if len(req.payload) == 0 {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(req.payload, v); err != nil {
+		return false, malformed("invalid payload: %s", err)
+	}
+
+	return true, nil
+}
+
+// load reads the object named by the id parameter into v, making sure it
+// belongs to account
+func (s *Server) load(c *gin.Context, v interface{}, owner func() int64, account *db.ACMEAccount) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return newProblem(errMalformed, http.StatusNotFound, "not found")
+	}
+
+	if err = s.authority.DB.First(v, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return newProblem(errMalformed, http.StatusNotFound, "not found")
+		}
+
+		return err
+	}
+
+	if owner() != account.ID {
+		return unauthorized("this belongs to another account")
+	}
+
+	return nil
+}
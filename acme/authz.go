@@ -0,0 +1,210 @@
+package acme
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/db"
+	"gorm.io/gorm"
+)
+
+type authorizationRequest struct {
+	Status string `json:"status"`
+}
+
+func (s *Server) challengeObject(c *gin.Context, challenge *db.ACMEChallenge) gin.H {
+	obj := gin.H{
+		"type":   challenge.Type,
+		"url":    s.url(c, "/chall/%d", challenge.ID),
+		"status": challenge.Status,
+		"token":  challenge.Token,
+	}
+
+	if challenge.Status == db.ACMEValid {
+		obj["validated"] = challenge.Validated.UTC().Format(time.RFC3339)
+	}
+
+	if p := decodeProblem(challenge.Error); p != nil {
+		obj["error"] = p
+	}
+
+	return obj
+}
+
+func (s *Server) authorizationObject(c *gin.Context, authz *db.ACMEAuthorization) (gin.H, error) {
+	challenges, err := db.ACMEAuthorizationChallenges(s.authority.DB, authz.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]gin.H, len(challenges))
+	for i := range challenges {
+		objs[i] = s.challengeObject(c, &challenges[i])
+	}
+
+	obj := gin.H{
+		"identifier": authz.Identifier,
+		"status":     authz.Status,
+		"expires":    authz.Expires.UTC().Format(time.RFC3339),
+		"challenges": objs,
+	}
+
+	if authz.Wildcard {
+		obj["wildcard"] = true
+	}
+
+	return obj, nil
+}
+
+// authorization returns or deactivates an authorization
+func (s *Server) authorization(c *gin.Context) {
+	req, account, err := s.verify(c, keyByKid)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	authz := &db.ACMEAuthorization{}
+	if err = s.load(c, authz, func() int64 { return authz.AccountID }, account); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var payload authorizationRequest
+	update, err := decodePayload(req, &payload)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if update {
+		if payload.Status != db.ACMEDeactivated {
+			s.fail(c, malformed("authorizations can only be deactivated"))
+			return
+		}
+
+		if authz.Status != db.ACMEPending && authz.Status != db.ACMEValid {
+			s.fail(c, malformed("a %s authorization can't be deactivated", authz.Status))
+			return
+		}
+
+		authz.Status = db.ACMEDeactivated
+		err = s.authority.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(authz).Error; err != nil {
+				return err
+			}
+
+			return s.updateOrder(tx, authz.OrderID)
+		})
+		if err != nil {
+			s.fail(c, err)
+			return
+		}
+	} else if authz.Status == db.ACMEPending && time.Now().After(authz.Expires) {
+		authz.Status = db.ACMEInvalid
+		if err = s.authority.DB.Save(authz).Error; err != nil {
+			s.fail(c, err)
+			return
+		}
+	}
+
+	obj, err := s.authorizationObject(c, authz)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	s.respond(c, http.StatusOK, obj)
+}
+
+// challenge returns a challenge, or starts validating it when the client
+// posts an empty object to say it's ready
+func (s *Server) challenge(c *gin.Context) {
+	req, account, err := s.verify(c, keyByKid)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	challenge := &db.ACMEChallenge{}
+	authz := &db.ACMEAuthorization{}
+	err = s.load(c, challenge, func() int64 {
+		if err := s.authority.DB.First(authz, challenge.AuthorizationID).Error; err != nil {
+			return 0
+		}
+
+		return authz.AccountID
+	}, account)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var payload struct{}
+	respond, err := decodePayload(req, &payload)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if respond && challenge.Status == db.ACMEPending {
+		if authz.Status != db.ACMEPending || time.Now().After(authz.Expires) {
+			s.fail(c, malformed("the authorization is %s", authz.Status))
+			return
+		}
+
+		challenge.Status = db.ACMEProcessing
+		if err = s.authority.DB.Save(challenge).Error; err != nil {
+			s.fail(c, err)
+			return
+		}
+
+		go s.validate(*challenge, *authz, keyAuthorization(challenge.Token, account.Thumbprint))
+	}
+
+	c.Header("Link", fmt.Sprintf("<%s>;rel=\"up\"", s.url(c, "/authz/%d", authz.ID)))
+	s.respond(c, http.StatusOK, s.challengeObject(c, challenge))
+}
+
+// validate checks a challenge and records the outcome on it, its
+// authorization and its order
+func (s *Server) validate(challenge db.ACMEChallenge, authz db.ACMEAuthorization, keyAuth string) {
+	p := s.validator.validate(challenge.Type, authz.Identifier.Value, challenge.Token, keyAuth)
+
+	fields := log.Fields{
+		"identifier": authz.Identifier.Value,
+		"type":       challenge.Type,
+	}
+
+	challenge.Status, authz.Status = db.ACMEValid, db.ACMEValid
+	challenge.Validated = time.Now()
+	if p != nil {
+		challenge.Status, authz.Status = db.ACMEInvalid, db.ACMEInvalid
+		challenge.Error = encodeProblem(p)
+		fields["error"] = p.Detail
+	}
+
+	fields["status"] = challenge.Status
+	log.WithFields(fields).Info("validated acme challenge")
+
+	err := s.authority.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&challenge).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Save(&authz).Error; err != nil {
+			return err
+		}
+
+		return s.updateOrder(tx, authz.OrderID)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err,
+			"challenge": challenge.ID,
+		}).Error("unable to record acme challenge validation")
+	}
+}
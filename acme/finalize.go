@@ -0,0 +1,176 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/db"
+)
+
+type finalizeRequest struct {
+	CSR string `json:"csr"`
+}
+
+// csrIdentifiers returns the names a CSR asks for, in the same form as order
+// identifiers
+func csrIdentifiers(csr *x509.CertificateRequest) (map[db.ACMEIdentifier]bool, error) {
+	if len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, newProblem(errBadCSR, http.StatusBadRequest, "only dns and ip names can be requested")
+	}
+
+	names := map[db.ACMEIdentifier]bool{}
+	for _, name := range csr.DNSNames {
+		names[db.ACMEIdentifier{Type: identifierDNS, Value: strings.ToLower(name)}] = true
+	}
+
+	for _, ip := range csr.IPAddresses {
+		names[db.ACMEIdentifier{Type: identifierIP, Value: ip.String()}] = true
+	}
+
+	if cn := csr.Subject.CommonName; len(cn) > 0 {
+		if ip := net.ParseIP(cn); ip != nil {
+			names[db.ACMEIdentifier{Type: identifierIP, Value: ip.String()}] = true
+		} else {
+			names[db.ACMEIdentifier{Type: identifierDNS, Value: strings.ToLower(cn)}] = true
+		}
+	}
+
+	return names, nil
+}
+
+// finalize issues the certificate of a ready order
+func (s *Server) finalize(c *gin.Context) {
+	req, account, err := s.verify(c, keyByKid)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	order, err := s.loadOrder(c, account)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if order.Status != db.ACMEReady {
+		s.fail(c, newProblem(errOrderNotReady, http.StatusForbidden, "the order is %s", order.Status))
+		return
+	}
+
+	var payload finalizeRequest
+	if _, err = decodePayload(req, &payload); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	der, err := unb64(payload.CSR)
+	if err != nil {
+		s.fail(c, newProblem(errBadCSR, http.StatusBadRequest, "invalid csr encoding"))
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.fail(c, newProblem(errBadCSR, http.StatusBadRequest, "invalid csr: %s", err))
+		return
+	}
+
+	if err = s.authority.ValidateCSR(csr); err != nil {
+		s.fail(c, badCSR(err))
+		return
+	}
+
+	names, err := csrIdentifiers(csr)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	matches := len(names) == len(order.Identifiers)
+	for _, id := range order.Identifiers {
+		matches = matches && names[id]
+	}
+
+	if !matches {
+		s.fail(c, newProblem(errBadCSR, http.StatusBadRequest, "the csr has to ask for exactly the order's identifiers"))
+		return
+	}
+
+	// the order is claimed before issuing, so that concurrent finalize
+	// requests can't both get a certificate for it
+	claimed, err := db.SetACMEOrderStatus(s.authority.DB, order.ID, db.ACMEReady, db.ACMEProcessing)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if !claimed {
+		s.fail(c, newProblem(errOrderNotReady, http.StatusForbidden, "the order is already being finalized"))
+		return
+	}
+
+	conf := s.authority.Config()
+	signed, err := s.authority.SignCSR(csr, certs.CSRParams{
+		Lifetime:   conf.GetDuration("acme.lifetime"),
+		ServerAuth: true,
+		Profile:    conf.GetString("acme.profile"),
+	})
+	if err != nil {
+		// nothing was issued, so the client may try again with another csr
+		if _, resetErr := db.SetACMEOrderStatus(s.authority.DB, order.ID, db.ACMEProcessing, db.ACMEReady); resetErr != nil {
+			log.WithFields(log.Fields{
+				"error": resetErr,
+				"order": order.ID,
+			}).Error("unable to reset acme order")
+		}
+
+		s.fail(c, badCSR(err))
+		return
+	}
+
+	block, _ := pem.Decode(signed)
+	if block == nil {
+		s.fail(c, errors.New("signed certificate is not pem encoded"))
+		return
+	}
+
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	order.Status = db.ACMEValid
+	order.Serial = certs.SerialString(crt.SerialNumber)
+	order.Certificate = string(signed)
+	if err = s.authority.DB.Save(order).Error; err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"account": account.ID,
+		"order":   order.ID,
+		"serial":  order.Serial,
+	}).Info("issued acme certificate")
+
+	s.respondOrder(c, http.StatusOK, order)
+}
+
+// badCSR turns policy violations into badCSR problems, leaving anything else
+// to be reported as an internal error
+func badCSR(err error) error {
+	var violation certs.PolicyViolation
+	if errors.As(err, &violation) {
+		return newProblem(errBadCSR, http.StatusBadRequest, "%s", err)
+	}
+
+	return err
+}
@@ -0,0 +1,246 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+)
+
+// jwsMessage is a JWS in the flattened JSON serialization, which is the only
+// one ACME allows
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	Kid   string          `json:"kid"`
+	JWK   json.RawMessage `json:"jwk"`
+}
+
+// jwk is a JSON web key as sent by ACME clients
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// jwsRequest is a verified JWS
+type jwsRequest struct {
+	header  jwsHeader
+	payload []byte
+
+	// key is the key the request was signed with
+	key crypto.PublicKey
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func unb64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// parseJWK returns the public key in a JWK. EC keys on P-256 and P-384, RSA
+// keys and Ed25519 keys are supported.
+func parseJWK(raw []byte) (crypto.PublicKey, error) {
+	var key jwk
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return nil, malformed("invalid jwk: %s", err)
+	}
+
+	switch key.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, newProblem(errBadSignatureAlgorithm, http.StatusBadRequest, "unsupported curve %q", key.Crv)
+		}
+
+		x, err := unb64(key.X)
+		if err != nil {
+			return nil, malformed("invalid jwk x")
+		}
+
+		y, err := unb64(key.Y)
+		if err != nil {
+			return nil, malformed("invalid jwk y")
+		}
+
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, malformed("jwk point is not on the curve")
+		}
+
+		return pub, nil
+	case "RSA":
+		n, err := unb64(key.N)
+		if err != nil {
+			return nil, malformed("invalid jwk n")
+		}
+
+		e, err := unb64(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, malformed("invalid jwk e")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, newProblem(errBadSignatureAlgorithm, http.StatusBadRequest, "unsupported curve %q", key.Crv)
+		}
+
+		x, err := unb64(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, malformed("invalid jwk x")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, newProblem(errBadSignatureAlgorithm, http.StatusBadRequest, "unsupported key type %q", key.Kty)
+	}
+}
+
+// encodeJWK returns pub as a JWK with only the required members, in the
+// lexicographic order RFC 7638 thumbprints are computed over
+func encodeJWK(pub crypto.PublicKey) ([]byte, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{pub.Curve.Params().Name, "EC", b64(pub.X.FillBytes(make([]byte, size))), b64(pub.Y.FillBytes(make([]byte, size)))})
+	case *rsa.PublicKey:
+		return json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{b64(big.NewInt(int64(pub.E)).Bytes()), "RSA", b64(pub.N.Bytes())})
+	case ed25519.PublicKey:
+		return json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{"Ed25519", "OKP", b64(pub)})
+	default:
+		return nil, malformed("unsupported key type %T", pub)
+	}
+}
+
+// thumbprint returns the base64url encoded RFC 7638 thumbprint of pub
+func thumbprint(pub crypto.PublicKey) (string, error) {
+	encoded, err := encodeJWK(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(encoded)
+	return b64(sum[:]), nil
+}
+
+// verifySignature checks a JWS signature made with alg by pub
+func verifySignature(alg string, pub crypto.PublicKey, signingInput, signature []byte) error {
+	bad := newProblem(errBadSignatureAlgorithm, http.StatusBadRequest, "algorithm %s doesn't match the key", alg)
+
+	switch alg {
+	case "ES256", "ES384":
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return bad
+		}
+
+		var digest []byte
+		if alg == "ES256" && key.Curve == elliptic.P256() {
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		} else if alg == "ES384" && key.Curve == elliptic.P384() {
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+		} else {
+			return bad
+		}
+
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return malformed("invalid signature length")
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return malformed("invalid signature")
+		}
+	case "RS256":
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return bad
+		}
+
+		sum := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature); err != nil {
+			return malformed("invalid signature")
+		}
+	case "EdDSA":
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return bad
+		}
+
+		if !ed25519.Verify(key, signingInput, signature) {
+			return malformed("invalid signature")
+		}
+	default:
+		return newProblem(errBadSignatureAlgorithm, http.StatusBadRequest, "unsupported algorithm %q, use ES256, ES384, RS256 or EdDSA", alg)
+	}
+
+	return nil
+}
+
+// parseJWS decodes a JWS, returning its protected header, payload and the
+// input its signature covers
+func parseJWS(body []byte) (*jwsMessage, *jwsHeader, []byte, error) {
+	msg := &jwsMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, nil, nil, malformed("request isn't a flattened JWS: %s", err)
+	}
+
+	protected, err := unb64(msg.Protected)
+	if err != nil {
+		return nil, nil, nil, malformed("invalid protected header encoding")
+	}
+
+	header := &jwsHeader{}
+	if err = json.Unmarshal(protected, header); err != nil {
+		return nil, nil, nil, malformed("invalid protected header: %s", err)
+	}
+
+	payload, err := unb64(msg.Payload)
+	if err != nil {
+		return nil, nil, nil, malformed("invalid payload encoding")
+	}
+
+	return msg, header, payload, nil
+}
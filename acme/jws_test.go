@@ -0,0 +1,274 @@
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/db"
+)
+
+// RFC 7638 section 3.1
+func TestThumbprint(t *testing.T) {
+	n, err := unb64("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp, err := thumbprint(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; tp != expected {
+		t.Fatalf("thumbprint %s, expected %s", tp, expected)
+	}
+}
+
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	ec256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]crypto.Signer{"ES256": ec256, "ES384": ec384, "RS256": rsaKey, "EdDSA": edKey}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	for alg, key := range testKeys(t) {
+		encoded, err := encodeJWK(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pub, err := parseJWK(encoded)
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+			t.Fatalf("%s: jwk decoded to another key", alg)
+		}
+	}
+}
+
+func TestParseJWKInvalid(t *testing.T) {
+	for name, raw := range map[string]string{
+		"not json":        `{`,
+		"unknown kty":     `{"kty":"oct","k":"c2VjcmV0"}`,
+		"p-521":           `{"kty":"EC","crv":"P-521","x":"AA","y":"AA"}`,
+		"off the curve":   `{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}`,
+		"okp x25519":      `{"kty":"OKP","crv":"X25519","x":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}`,
+		"short ed25519":   `{"kty":"OKP","crv":"Ed25519","x":"AAAA"}`,
+		"rsa without e":   `{"kty":"RSA","n":"AQAB"}`,
+		"rsa e too large": `{"kty":"RSA","n":"AQAB","e":"AQIDBAU"}`,
+	} {
+		if _, err := parseJWK([]byte(raw)); err == nil {
+			t.Errorf("%s: jwk was accepted", name)
+		}
+	}
+}
+
+// sign returns the JWS signature of input with key, as alg
+func sign(t *testing.T, alg string, key crypto.Signer, input []byte) []byte {
+	switch alg {
+	case "ES256", "ES384":
+		ecKey := key.(*ecdsa.PrivateKey)
+		digest := sha256.Sum256(input)
+		hashed := digest[:]
+		if alg == "ES384" {
+			sum := crypto.SHA384.New()
+			sum.Write(input)
+			hashed = sum.Sum(nil)
+		}
+
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, hashed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		return append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	case "RS256":
+		digest := sha256.Sum256(input)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return sig
+	default:
+		return ed25519.Sign(key.(ed25519.PrivateKey), input)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	keys := testKeys(t)
+	input := []byte("protected.payload")
+
+	for alg, key := range keys {
+		sig := sign(t, alg, key, input)
+		if err := verifySignature(alg, key.Public(), input, sig); err != nil {
+			t.Errorf("%s: valid signature refused: %s", alg, err)
+		}
+
+		if err := verifySignature(alg, key.Public(), []byte("protected.tampered"), sig); err == nil {
+			t.Errorf("%s: signature accepted over other input", alg)
+		}
+
+		for other, otherKey := range keys {
+			if other != alg && verifySignature(other, otherKey.Public(), input, sig) == nil {
+				t.Errorf("%s signature accepted as %s", alg, other)
+			}
+
+			if other != alg && verifySignature(alg, otherKey.Public(), input, sig) == nil {
+				t.Errorf("%s signature accepted for a %s key", alg, other)
+			}
+		}
+	}
+
+	for _, alg := range []string{"none", "HS256", "ES512", ""} {
+		if err := verifySignature(alg, keys["EdDSA"].Public(), input, nil); err == nil {
+			t.Errorf("algorithm %q was accepted", alg)
+		}
+	}
+}
+
+// testServer returns an ACME server with a database but no certificate
+// authority, enough to verify requests
+func testServer(t *testing.T) *Server {
+	a, err := certs.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.DB, err = db.Open(filepath.Join(t.TempDir(), "zcert.sqlite3"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(a)
+	s.prefix = "/acme"
+	return s
+}
+
+// signedRequest builds a JWS request for url signed with key, carrying the
+// key in jwk
+func signedRequest(t *testing.T, key ed25519.PrivateKey, nonce, url string, payload []byte) []byte {
+	jwk, err := encodeJWK(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := json.Marshal(jwsHeader{Alg: "EdDSA", Nonce: nonce, URL: url, JWK: jwk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := jwsMessage{Protected: b64(header), Payload: b64(payload)}
+	msg.Signature = b64(ed25519.Sign(key, []byte(msg.Protected+"."+msg.Payload)))
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func verifyRequest(s *Server, body []byte) error {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "http://acme.example.com/acme/new-account", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/jose+json")
+
+	_, _, err := s.verify(c, keyByJWK)
+	return err
+}
+
+func problemType(err error) string {
+	var p *problem
+	if errors.As(err, &p) {
+		return strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:")
+	}
+
+	return ""
+}
+
+func TestVerifyReplay(t *testing.T) {
+	s := testServer(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "http://acme.example.com/acme/new-account"
+	body := signedRequest(t, key, s.nonces.New(), url, []byte(`{}`))
+
+	if err = verifyRequest(s, body); err != nil {
+		t.Fatalf("valid request refused: %s", err)
+	}
+
+	if err = verifyRequest(s, body); problemType(err) != errBadNonce {
+		t.Fatalf("replayed request returned %v, expected badNonce", err)
+	}
+
+	if err = verifyRequest(s, signedRequest(t, key, "made-up", url, []byte(`{}`))); problemType(err) != errBadNonce {
+		t.Fatalf("request with an unknown nonce returned %v, expected badNonce", err)
+	}
+
+	// a rejected request still uses up its nonce
+	nonce := s.nonces.New()
+	if err = verifyRequest(s, signedRequest(t, key, nonce, "http://acme.example.com/acme/new-order", []byte(`{}`))); problemType(err) != errUnauthorized {
+		t.Fatalf("request for another url returned %v, expected unauthorized", err)
+	}
+
+	if err = verifyRequest(s, signedRequest(t, key, nonce, url, []byte(`{}`))); problemType(err) != errBadNonce {
+		t.Fatalf("request reusing the nonce of a rejected request returned %v, expected badNonce", err)
+	}
+
+	tampered := signedRequest(t, key, s.nonces.New(), url, []byte(`{}`))
+	var msg jwsMessage
+	if err = json.Unmarshal(tampered, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	msg.Payload = b64([]byte(`{"termsOfServiceAgreed":true}`))
+	if tampered, err = json.Marshal(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = verifyRequest(s, tampered); problemType(err) != errMalformed {
+		t.Fatalf("request with a tampered payload returned %v, expected malformed", err)
+	}
+}
@@ -0,0 +1,78 @@
+package acme
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/stormentt/zcert/util/random"
+)
+
+const (
+	nonceLifetime = time.Hour
+
+	// maxNonces bounds the store, as anyone may ask for nonces. Past it the
+	// oldest nonces are dropped and clients using them get badNonce, which
+	// ACME clients retry.
+	maxNonces = 10000
+)
+
+type nonceRecord struct {
+	nonce  string
+	issued time.Time
+}
+
+// nonceStore hands out the nonces clients have to put in their next request.
+// Each nonce is accepted once, and only within nonceLifetime. Nonces are kept
+// oldest first, so expired ones are pruned from the front.
+type nonceStore struct {
+	mu     sync.Mutex
+	limit  int
+	order  *list.List
+	nonces map[string]*list.Element
+}
+
+func newNonceStore() *nonceStore {
+	return &nonceStore{
+		limit:  maxNonces,
+		order:  list.New(),
+		nonces: map[string]*list.Element{},
+	}
+}
+
+func (ns *nonceStore) remove(e *list.Element) {
+	ns.order.Remove(e)
+	delete(ns.nonces, e.Value.(nonceRecord).nonce)
+}
+
+func (ns *nonceStore) New() string {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	now := time.Now()
+	for e := ns.order.Front(); e != nil; e = ns.order.Front() {
+		if now.Sub(e.Value.(nonceRecord).issued) <= nonceLifetime && ns.order.Len() < ns.limit {
+			break
+		}
+
+		ns.remove(e)
+	}
+
+	nonce := random.AlphaNum(32)
+	ns.nonces[nonce] = ns.order.PushBack(nonceRecord{nonce: nonce, issued: now})
+	return nonce
+}
+
+// Use consumes nonce, reporting whether it was valid
+func (ns *nonceStore) Use(nonce string) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	e, ok := ns.nonces[nonce]
+	if !ok {
+		return false
+	}
+
+	ns.remove(e)
+	return time.Since(e.Value.(nonceRecord).issued) <= nonceLifetime
+}
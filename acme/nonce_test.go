@@ -0,0 +1,67 @@
+package acme
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceReplay(t *testing.T) {
+	ns := newNonceStore()
+	nonce := ns.New()
+
+	if !ns.Use(nonce) {
+		t.Fatal("fresh nonce was refused")
+	}
+
+	if ns.Use(nonce) {
+		t.Fatal("nonce was accepted twice")
+	}
+
+	if ns.Use("") || ns.Use("never-issued") {
+		t.Fatal("unknown nonce was accepted")
+	}
+}
+
+func TestNonceExpiry(t *testing.T) {
+	ns := newNonceStore()
+	nonce := ns.New()
+
+	ns.nonces[nonce].Value = nonceRecord{nonce: nonce, issued: time.Now().Add(-nonceLifetime - time.Second)}
+
+	if ns.Use(nonce) {
+		t.Fatal("expired nonce was accepted")
+	}
+
+	// expired nonces are pruned by the next New
+	stale := ns.New()
+	ns.nonces[stale].Value = nonceRecord{nonce: stale, issued: time.Now().Add(-nonceLifetime - time.Second)}
+	fresh := ns.New()
+
+	if _, ok := ns.nonces[stale]; ok || ns.order.Len() != 1 {
+		t.Fatalf("%d nonces kept after pruning, expected only the fresh one", ns.order.Len())
+	}
+
+	if !ns.Use(fresh) {
+		t.Fatal("fresh nonce was refused after pruning")
+	}
+}
+
+func TestNonceLimit(t *testing.T) {
+	ns := newNonceStore()
+	ns.limit = 10
+
+	var issued []string
+	for i := 0; i < 25; i++ {
+		issued = append(issued, ns.New())
+	}
+
+	if ns.order.Len() != ns.limit || len(ns.nonces) != ns.limit {
+		t.Fatalf("store holds %d nonces, expected the limit of %d", ns.order.Len(), ns.limit)
+	}
+
+	for i, nonce := range issued {
+		if used, kept := ns.Use(nonce), i >= len(issued)-ns.limit; used != kept {
+			t.Errorf("nonce %d: accepted %t, expected %t", i, used, kept)
+		}
+	}
+}
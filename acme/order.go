@@ -0,0 +1,310 @@
+package acme
+
+import (
+	"crypto/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stormentt/zcert/db"
+	"gorm.io/gorm"
+)
+
+// identifier types
+const (
+	identifierDNS = "dns"
+	identifierIP  = "ip"
+)
+
+const orderLifetime = time.Hour * 24 * 7
+
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+type orderRequest struct {
+	Identifiers []db.ACMEIdentifier `json:"identifiers"`
+	NotBefore   string              `json:"notBefore"`
+	NotAfter    string              `json:"notAfter"`
+}
+
+// normalizeIdentifier checks that an identifier can be issued for, returning
+// it in the form it's compared against CSRs in
+func normalizeIdentifier(id db.ACMEIdentifier) (db.ACMEIdentifier, error) {
+	switch id.Type {
+	case identifierDNS:
+		name := strings.TrimSuffix(strings.ToLower(id.Value), ".")
+		labels := strings.Split(strings.TrimPrefix(name, "*."), ".")
+		if len(name) > 253 || net.ParseIP(name) != nil {
+			return id, newProblem(errRejectedIdentifier, http.StatusBadRequest, "%q is not a valid dns name", id.Value)
+		}
+
+		for _, label := range labels {
+			if !dnsLabel.MatchString(label) {
+				return id, newProblem(errRejectedIdentifier, http.StatusBadRequest, "%q is not a valid dns name", id.Value)
+			}
+		}
+
+		return db.ACMEIdentifier{Type: identifierDNS, Value: name}, nil
+	case identifierIP:
+		ip := net.ParseIP(id.Value)
+		if ip == nil {
+			return id, newProblem(errRejectedIdentifier, http.StatusBadRequest, "%q is not a valid ip address", id.Value)
+		}
+
+		return db.ACMEIdentifier{Type: identifierIP, Value: ip.String()}, nil
+	default:
+		return id, newProblem(errUnsupportedIdentifier, http.StatusBadRequest, "identifier type %q is not supported", id.Type)
+	}
+}
+
+// challengeTypes returns the challenges an identifier can be validated with
+func challengeTypes(id db.ACMEIdentifier, wildcard bool) []string {
+	switch {
+	case id.Type == identifierIP:
+		return []string{challengeHTTP01}
+	case wildcard:
+		return []string{challengeDNS01}
+	default:
+		return []string{challengeHTTP01, challengeDNS01}
+	}
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return b64(buf), nil
+}
+
+func (s *Server) orderObject(c *gin.Context, order *db.ACMEOrder) (gin.H, error) {
+	authorizations, err := db.ACMEOrderAuthorizations(s.authority.DB, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, len(authorizations))
+	for i, authz := range authorizations {
+		urls[i] = s.url(c, "/authz/%d", authz.ID)
+	}
+
+	obj := gin.H{
+		"status":         order.Status,
+		"expires":        order.Expires.UTC().Format(time.RFC3339),
+		"identifiers":    order.Identifiers,
+		"authorizations": urls,
+		"finalize":       s.url(c, "/order/%d/finalize", order.ID),
+	}
+
+	if order.Status == db.ACMEValid {
+		obj["certificate"] = s.url(c, "/cert/%d", order.ID)
+	}
+
+	if p := decodeProblem(order.Error); p != nil {
+		obj["error"] = p
+	}
+
+	return obj, nil
+}
+
+func (s *Server) respondOrder(c *gin.Context, status int, order *db.ACMEOrder) {
+	obj, err := s.orderObject(c, order)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	c.Header("Location", s.url(c, "/order/%d", order.ID))
+	s.respond(c, status, obj)
+}
+
+func (s *Server) newOrder(c *gin.Context) {
+	req, account, err := s.verify(c, keyByKid)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var payload orderRequest
+	if _, err = decodePayload(req, &payload); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if len(payload.NotBefore) > 0 || len(payload.NotAfter) > 0 {
+		s.fail(c, malformed("notBefore and notAfter are not supported"))
+		return
+	}
+
+	if len(payload.Identifiers) == 0 {
+		s.fail(c, malformed("orders need at least one identifier"))
+		return
+	}
+
+	seen := map[db.ACMEIdentifier]bool{}
+	identifiers := []db.ACMEIdentifier{}
+	for _, id := range payload.Identifiers {
+		id, err = normalizeIdentifier(id)
+		if err != nil {
+			s.fail(c, err)
+			return
+		}
+
+		if !seen[id] {
+			seen[id] = true
+			identifiers = append(identifiers, id)
+		}
+	}
+
+	order := &db.ACMEOrder{
+		AccountID:   account.ID,
+		Status:      db.ACMEPending,
+		Identifiers: identifiers,
+		Expires:     time.Now().Add(orderLifetime),
+	}
+
+	err = s.authority.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+
+		for _, id := range identifiers {
+			wildcard := strings.HasPrefix(id.Value, "*.")
+			authz := &db.ACMEAuthorization{
+				OrderID:    order.ID,
+				AccountID:  account.ID,
+				Identifier: db.ACMEIdentifier{Type: id.Type, Value: strings.TrimPrefix(id.Value, "*.")},
+				Wildcard:   wildcard,
+				Status:     db.ACMEPending,
+				Expires:    order.Expires,
+			}
+
+			if err := tx.Create(authz).Error; err != nil {
+				return err
+			}
+
+			for _, challengeType := range challengeTypes(id, wildcard) {
+				token, err := newToken()
+				if err != nil {
+					return err
+				}
+
+				challenge := &db.ACMEChallenge{
+					AuthorizationID: authz.ID,
+					Type:            challengeType,
+					Token:           token,
+					Status:          db.ACMEPending,
+				}
+
+				if err := tx.Create(challenge).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	s.respondOrder(c, http.StatusCreated, order)
+}
+
+// loadOrder returns the order named by the id parameter, marking it invalid
+// if it expired before it was finalized
+func (s *Server) loadOrder(c *gin.Context, account *db.ACMEAccount) (*db.ACMEOrder, error) {
+	order := &db.ACMEOrder{}
+	if err := s.load(c, order, func() int64 { return order.AccountID }, account); err != nil {
+		return nil, err
+	}
+
+	if (order.Status == db.ACMEPending || order.Status == db.ACMEReady) && time.Now().After(order.Expires) {
+		order.Status = db.ACMEInvalid
+		order.Error = encodeProblem(malformed("order expired"))
+		if err := s.authority.DB.Save(order).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func (s *Server) order(c *gin.Context) {
+	_, account, err := s.verify(c, keyByKid)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	order, err := s.loadOrder(c, account)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	s.respondOrder(c, http.StatusOK, order)
+}
+
+func (s *Server) certificate(c *gin.Context) {
+	_, account, err := s.verify(c, keyByKid)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	order, err := s.loadOrder(c, account)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if order.Status != db.ACMEValid {
+		s.fail(c, newProblem(errMalformed, http.StatusNotFound, "the order has no certificate"))
+		return
+	}
+
+	c.Data(http.StatusOK, "application/pem-certificate-chain", []byte(order.Certificate))
+}
+
+// updateOrder moves an order on once its authorizations are decided: to ready
+// when all are valid, to invalid as soon as one isn't
+func (s *Server) updateOrder(tx *gorm.DB, orderID int64) error {
+	order := &db.ACMEOrder{}
+	if err := tx.First(order, orderID).Error; err != nil {
+		return err
+	}
+
+	if order.Status != db.ACMEPending {
+		return nil
+	}
+
+	authorizations, err := db.ACMEOrderAuthorizations(tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	ready := true
+	for _, authz := range authorizations {
+		switch authz.Status {
+		case db.ACMEValid:
+		case db.ACMEPending:
+			ready = false
+		default:
+			order.Status = db.ACMEInvalid
+			order.Error = encodeProblem(unauthorized("authorization for %s is %s", authz.Identifier.Value, authz.Status))
+			return tx.Save(order).Error
+		}
+	}
+
+	if ready {
+		order.Status = db.ACMEReady
+		return tx.Save(order).Error
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// error types from RFC 8555 section 6.7
+const (
+	errAccountDoesNotExist   = "accountDoesNotExist"
+	errAlreadyRevoked        = "alreadyRevoked"
+	errBadCSR                = "badCSR"
+	errBadNonce              = "badNonce"
+	errBadRevocationReason   = "badRevocationReason"
+	errBadSignatureAlgorithm = "badSignatureAlgorithm"
+	errConnection            = "connection"
+	errDNS                   = "dns"
+	errIncorrectResponse     = "incorrectResponse"
+	errMalformed             = "malformed"
+	errOrderNotReady         = "orderNotReady"
+	errRejectedIdentifier    = "rejectedIdentifier"
+	errServerInternal        = "serverInternal"
+	errUnauthorized          = "unauthorized"
+	errUnsupportedIdentifier = "unsupportedIdentifier"
+)
+
+// problem is an RFC 7807 problem document
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+func newProblem(kind string, status int, format string, args ...interface{}) *problem {
+	return &problem{
+		Type:   "urn:ietf:params:acme:error:" + kind,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func malformed(format string, args ...interface{}) *problem {
+	return newProblem(errMalformed, http.StatusBadRequest, format, args...)
+}
+
+func unauthorized(format string, args ...interface{}) *problem {
+	return newProblem(errUnauthorized, http.StatusForbidden, format, args...)
+}
+
+func serverInternal() *problem {
+	return newProblem(errServerInternal, http.StatusInternalServerError, "internal server error")
+}
+
+// encodeProblem returns p as stored with invalid orders and challenges
+func encodeProblem(p *problem) string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+// decodeProblem reverses encodeProblem, returning nil for an empty string
+func decodeProblem(s string) *problem {
+	if len(s) == 0 {
+		return nil
+	}
+
+	p := &problem{}
+	if err := json.Unmarshal([]byte(s), p); err != nil {
+		return nil
+	}
+
+	return p
+}
@@ -0,0 +1,122 @@
+package acme
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/db"
+)
+
+type revokeRequest struct {
+	Certificate string `json:"certificate"`
+	Reason      *int   `json:"reason"`
+}
+
+// reasonName returns the name of a CRLReason code
+func reasonName(code int) (string, bool) {
+	for name, c := range db.ReasonCodes {
+		if c == code {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// revokeCert revokes a certificate, signed either by the account that ordered
+// it or by the certificate's own key
+func (s *Server) revokeCert(c *gin.Context) {
+	req, account, err := s.verify(c, keyByEither)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var payload revokeRequest
+	if _, err = decodePayload(req, &payload); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	der, err := unb64(payload.Certificate)
+	if err != nil {
+		s.fail(c, malformed("invalid certificate encoding"))
+		return
+	}
+
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		s.fail(c, malformed("invalid certificate: %s", err))
+		return
+	}
+
+	if !s.authority.Issued(crt) {
+		s.fail(c, unauthorized("the certificate wasn't issued by this authority"))
+		return
+	}
+
+	serial := certs.SerialString(crt.SerialNumber)
+	record, err := db.CertBySerial(s.authority.DB, serial)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if record == nil {
+		s.fail(c, unauthorized("unknown certificate %s", serial))
+		return
+	}
+
+	if len(req.header.Kid) > 0 {
+		owned, err := db.ACMEAccountHasSerial(s.authority.DB, account.ID, serial)
+		if err != nil {
+			s.fail(c, err)
+			return
+		}
+
+		if !owned {
+			s.fail(c, unauthorized("the certificate wasn't ordered by this account"))
+			return
+		}
+	} else {
+		signer, err := certs.SPKIFingerprint(req.key)
+		if err != nil {
+			s.fail(c, err)
+			return
+		}
+
+		if signer != record.SPKIFingerprint {
+			s.fail(c, unauthorized("the request isn't signed by the certificate's key"))
+			return
+		}
+	}
+
+	reason := db.ReasonUnspecified
+	if payload.Reason != nil {
+		var ok bool
+		if reason, ok = reasonName(*payload.Reason); !ok {
+			s.fail(c, newProblem(errBadRevocationReason, http.StatusBadRequest, "unknown revocation reason %d", *payload.Reason))
+			return
+		}
+	}
+
+	if record.Revoked {
+		s.fail(c, newProblem(errAlreadyRevoked, http.StatusBadRequest, "certificate %s is already revoked", serial))
+		return
+	}
+
+	if err = record.Revoke(s.authority.DB, reason); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"serial": serial,
+		"reason": reason,
+	}).Info("revoked certificate over acme")
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,143 @@
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// challenge types
+const (
+	challengeHTTP01 = "http-01"
+	challengeDNS01  = "dns-01"
+)
+
+const validationTimeout = time.Second * 10
+
+// keyAuthorization is what the client has to publish for a challenge token,
+// tying the token to its account key
+func keyAuthorization(token, accountThumbprint string) string {
+	return token + "." + accountThumbprint
+}
+
+// validator checks challenge responses. The port http-01 challenges are
+// fetched from and the resolvers dns-01 challenges are looked up with are
+// configurable, so challenges can be pointed at stub responders.
+type validator struct {
+	httpPort  int
+	resolvers []string
+}
+
+func newValidator(conf *viper.Viper) *validator {
+	return &validator{
+		httpPort:  conf.GetInt("acme.http_port"),
+		resolvers: conf.GetStringSlice("acme.resolvers"),
+	}
+}
+
+func (v *validator) validate(challengeType, domain, token, keyAuth string) *problem {
+	switch challengeType {
+	case challengeHTTP01:
+		return v.http01(domain, token, keyAuth)
+	case challengeDNS01:
+		return v.dns01(domain, keyAuth)
+	default:
+		return malformed("unknown challenge type %q", challengeType)
+	}
+}
+
+// http01 fetches the key authorization from the domain's well-known path, as
+// in RFC 8555 section 8.3
+func (v *validator) http01(domain, token, keyAuth string) *problem {
+	host := domain
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	url := fmt.Sprintf("http://%s:%d/.well-known/acme-challenge/%s", host, v.httpPort, token)
+	client := &http.Client{Timeout: validationTimeout}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return newProblem(errConnection, http.StatusBadRequest, "fetching %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newProblem(errIncorrectResponse, http.StatusForbidden, "fetching %s: status %d", url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return newProblem(errConnection, http.StatusBadRequest, "reading %s: %s", url, err)
+	}
+
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return newProblem(errIncorrectResponse, http.StatusForbidden, "%s doesn't hold the expected key authorization", url)
+	}
+
+	return nil
+}
+
+// dns01 looks for the digest of the key authorization in the TXT records of
+// _acme-challenge.<domain>, as in RFC 8555 section 8.4
+func (v *validator) dns01(domain, keyAuth string) *problem {
+	sum := sha256.Sum256([]byte(keyAuth))
+	expected := b64(sum[:])
+	name := "_acme-challenge." + domain
+
+	ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+	defer cancel()
+
+	var lastErr error
+	for _, resolver := range v.resolverList() {
+		records, err := resolver.LookupTXT(ctx, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, record := range records {
+			if record == expected {
+				return nil
+			}
+		}
+
+		return newProblem(errIncorrectResponse, http.StatusForbidden, "no TXT record of %s holds the expected digest", name)
+	}
+
+	return newProblem(errDNS, http.StatusBadRequest, "looking up %s: %s", name, lastErr)
+}
+
+// resolverList returns a resolver for every configured address, or the
+// system's resolver when none are configured
+func (v *validator) resolverList() []*net.Resolver {
+	if len(v.resolvers) == 0 {
+		return []*net.Resolver{net.DefaultResolver}
+	}
+
+	resolvers := make([]*net.Resolver, len(v.resolvers))
+	for i, address := range v.resolvers {
+		address := address
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "53")
+		}
+
+		resolvers[i] = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, address)
+			},
+		}
+	}
+
+	return resolvers
+}
@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/util"
+)
+
+// The signing agent holds the CA keys in a separate process and signs with
+// them on behalf of the server over a unix socket, so that the server never
+// sees the keys themselves. Keys are named like the signer backend names
+// them: ca.key and retired/<key id>/ca.key.
+
+type UnknownKeyError struct {
+	name string
+}
+
+type PeerNotAllowedError struct {
+	uid int
+}
+
+func (e *UnknownKeyError) Error() string {
+	return fmt.Sprintf("the signing agent has no key %s", e.name)
+}
+
+func (e *PeerNotAllowedError) Error() string {
+	return fmt.Sprintf("uid %d is not allowed to use the signing agent", e.uid)
+}
+
+type PublicArgs struct {
+	Key string
+}
+
+type PublicReply struct {
+	PKIX []byte
+}
+
+type SignArgs struct {
+	Key     string
+	Message []byte
+	Hash    crypto.Hash
+}
+
+type SignReply struct {
+	Signature []byte
+}
+
+// Agent is the RPC service served by the signing agent
+type Agent struct {
+	keys map[string]crypto.Signer
+}
+
+func (a *Agent) key(name string) (crypto.Signer, error) {
+	key, ok := a.keys[name]
+	if !ok {
+		return nil, &UnknownKeyError{name: name}
+	}
+
+	return key, nil
+}
+
+func (a *Agent) Public(args PublicArgs, reply *PublicReply) error {
+	key, err := a.key(args.Key)
+	if err != nil {
+		return err
+	}
+
+	reply.PKIX, err = x509.MarshalPKIXPublicKey(key.Public())
+	return err
+}
+
+func (a *Agent) Sign(args SignArgs, reply *SignReply) error {
+	key, err := a.key(args.Key)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"key":  args.Key,
+		"size": len(args.Message),
+	}).Info("signing")
+
+	reply.Signature, err = key.Sign(rand.Reader, args.Message, args.Hash)
+	return err
+}
+
+func allowed(uid int, uids []int) bool {
+	for _, u := range uids {
+		if u == uid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Serve listens on socket and signs with keys for connections from processes
+// running as one of uids, or as the agent's own user when uids is empty
+func Serve(socket string, keys map[string]crypto.Signer, uids []int) error {
+	if len(uids) == 0 {
+		uids = []int{os.Getuid()}
+	}
+
+	server := rpc.NewServer()
+	if err := server.Register(&Agent{keys: keys}); err != nil {
+		return err
+	}
+
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	ln, err := util.ListenUnix(socket)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	// other users can only connect when they've been allowed, and are then
+	// checked by their peer credentials
+	if len(uids) > 1 || uids[0] != os.Getuid() {
+		if err = os.Chmod(socket, 0666); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"socket": socket,
+		"uids":   uids,
+	}).Info("signing agent listening")
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		uid, err := peerUID(conn.(*net.UnixConn))
+		if err == nil && !allowed(uid, uids) {
+			err = &PeerNotAllowedError{uid: uid}
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Warn("refusing signing agent connection")
+
+			conn.Close()
+			continue
+		}
+
+		go server.ServeConn(conn)
+	}
+}
+
+// Client talks to a signing agent, reconnecting when the agent restarts
+type Client struct {
+	socket string
+
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+func Dial(socket string) (*Client, error) {
+	client, err := rpc.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{socket: socket, client: client}, nil
+}
+
+func (c *Client) call(method string, args, reply interface{}) error {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+
+	err := client.Call(method, args, reply)
+	if err != rpc.ErrShutdown {
+		return err
+	}
+
+	client, err = rpc.Dial("unix", c.socket)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.client = client
+	c.mu.Unlock()
+
+	return client.Call(method, args, reply)
+}
+
+// Signer returns a crypto.Signer for the named key held by the agent
+func (c *Client) Signer(name string) (crypto.Signer, error) {
+	var reply PublicReply
+	if err := c.call("Agent.Public", PublicArgs{Key: name}, &reply); err != nil {
+		return nil, err
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(reply.PKIX)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteSigner{client: c, name: name, pub: pub}, nil
+}
+
+type remoteSigner struct {
+	client *Client
+	name   string
+	pub    crypto.PublicKey
+}
+
+func (rs *remoteSigner) Public() crypto.PublicKey {
+	return rs.pub
+}
+
+func (rs *remoteSigner) Sign(_ io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var reply SignReply
+	args := SignArgs{
+		Key:     rs.name,
+		Message: message,
+		Hash:    opts.HashFunc(),
+	}
+
+	if err := rs.client.call("Agent.Sign", args, &reply); err != nil {
+		return nil, err
+	}
+
+	return reply.Signature, nil
+}
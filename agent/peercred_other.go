@@ -0,0 +1,17 @@
+//go:build !linux
+
+package agent
+
+import (
+	"net"
+)
+
+type PeerCredUnsupportedError struct{}
+
+func (e *PeerCredUnsupportedError) Error() string {
+	return "peer credential checks are only supported on linux"
+}
+
+func peerUID(conn *net.UnixConn) (int, error) {
+	return 0, &PeerCredUnsupportedError{}
+}
@@ -46,21 +46,39 @@ type SignCertReq struct {
 	SecurityBlock
 }
 
-func (scr SignCertReq) Validate() error {
-	if scr.Nonce == "" {
+// SignCACertReq asks for a subordinate certificate authority. It is only
+// accepted on admin routes.
+type SignCACertReq struct {
+	CSR    string         `json:"csr"`
+	Params certs.CAParams `json:"params"`
+
+	SecurityBlock
+}
+
+// SignSSHReq asks for an OpenSSH certificate for PublicKey, given in
+// authorized_keys format
+type SignSSHReq struct {
+	PublicKey string          `json:"public_key"`
+	Params    certs.SSHParams `json:"params"`
+
+	SecurityBlock
+}
+
+func (sb SecurityBlock) Validate() error {
+	if sb.Nonce == "" {
 		return &NoNonceError{}
 	}
 
-	if len(scr.Nonce) != NonceLength {
+	if len(sb.Nonce) != NonceLength {
 		return &InvalidNonceError{}
 	}
 
-	if scr.RequestTime.IsZero() {
+	if sb.RequestTime.IsZero() {
 		return &NoRequestTimeError{}
 	}
 
-	if time.Since(scr.RequestTime) > MaxRequestAge {
-		return &RequestTimeTooOldError{when: scr.RequestTime}
+	if time.Since(sb.RequestTime) > MaxRequestAge {
+		return &RequestTimeTooOldError{when: sb.RequestTime}
 	}
 
 	return nil
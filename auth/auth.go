@@ -11,15 +11,36 @@ import (
 )
 
 type HMACMismatchError struct{}
+type NoAdminKeyError struct{}
 
 func (e *HMACMismatchError) Error() string {
 	return "hmac mismatch error"
 }
 
+func (e *NoAdminKeyError) Error() string {
+	return "no adminkey configured"
+}
+
 const HMACLength = 32 // 256 / 8
 
+// AdminKey returns the key in conf used to authenticate privileged requests
+// such as signing subordinate certificate authorities. Admin requests are
+// refused outright when it isn't configured.
+func AdminKey(conf *viper.Viper) (string, error) {
+	adminkey := conf.GetString("adminkey")
+	if len(adminkey) == 0 {
+		return "", &NoAdminKeyError{}
+	}
+
+	return adminkey, nil
+}
+
 func CheckHMAC(expectedHMAC []byte, body []byte) (bool, error) {
-	calcHMAC, err := CalcHMAC(body)
+	return CheckHMACWithKey(viper.GetString("authkey"), expectedHMAC, body)
+}
+
+func CheckHMACWithKey(key string, expectedHMAC []byte, body []byte) (bool, error) {
+	calcHMAC, err := CalcHMACWithKey(key, body)
 	if err != nil {
 		return false, err
 	}
@@ -37,9 +58,11 @@ func CheckHMAC(expectedHMAC []byte, body []byte) (bool, error) {
 }
 
 func CalcHMAC(body []byte) ([]byte, error) {
-	authkey := viper.GetString("authkey")
+	return CalcHMACWithKey(viper.GetString("authkey"), body)
+}
 
-	b2, err := blake2b.New256([]byte(authkey))
+func CalcHMACWithKey(key string, body []byte) ([]byte, error) {
+	b2, err := blake2b.New256([]byte(key))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,70 @@
+package certs
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/stormentt/zcert/agent"
+)
+
+type AgentReadOnlyError struct {
+	op string
+}
+
+func (e *AgentReadOnlyError) Error() string {
+	return fmt.Sprintf("can't %s through the signing agent, stop it and run this with agent.backend as ca.signer", e.op)
+}
+
+// AgentSocket returns the unix socket of the signing agent
+func (a *Authority) AgentSocket() string {
+	if path := a.conf.GetString("agent.socket"); len(path) > 0 {
+		return path
+	}
+
+	return a.storagePath("signer.sock")
+}
+
+// AgentKeys returns every key loaded by LoadCA, named like the signer backend
+// names them
+func (a *Authority) AgentKeys() map[string]crypto.Signer {
+	keys := map[string]crypto.Signer{
+		"ca.key": a.Signer,
+	}
+
+	for _, retired := range a.Retired {
+		keys[retiredName(KeyID(retired.Cert), "ca.key")] = retired.Key
+	}
+
+	if a.SSHSigner != nil {
+		keys[sshKeyName] = a.SSHSigner
+	}
+
+	return keys
+}
+
+// agentBackend signs with keys held by a zcert signer process, so that the
+// server never has the keys in memory
+type agentBackend struct {
+	client *agent.Client
+}
+
+func newAgentBackend(socket string) (SignerBackend, error) {
+	client, err := agent.Dial(socket)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agentBackend{client: client}, nil
+}
+
+func (ab *agentBackend) LoadKey(name string) (crypto.Signer, error) {
+	return ab.client.Signer(name)
+}
+
+func (ab *agentBackend) GenerateKey(name, keyType string) (crypto.Signer, error) {
+	return nil, &AgentReadOnlyError{op: "generate keys"}
+}
+
+func (ab *agentBackend) RenameKey(from, to string) error {
+	return &AgentReadOnlyError{op: "rename keys"}
+}
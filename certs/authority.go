@@ -0,0 +1,200 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/zcert/db"
+	"gorm.io/gorm"
+)
+
+// Authority is a certificate authority hosted by zcert. Without a cas section
+// in the config there is a single authority with an empty name, configured by
+// the top level settings. Otherwise every entry of cas is an authority, whose
+// settings override the top level ones.
+type Authority struct {
+	Name string
+
+	Cert   *x509.Certificate
+	Signer crypto.Signer
+
+	// Chain holds the certificates above Cert, ending in the root. It is
+	// empty when Cert is itself the root.
+	Chain []*x509.Certificate
+
+	Retired []*RetiredCA
+
+	// SSHSigner is the key of the SSH certificate authority, nil unless one
+	// was created with zcert ca ssh-init
+	SSHSigner crypto.Signer
+
+	DB *gorm.DB
+
+	conf    *viper.Viper
+	backend SignerBackend
+
+	// passphrase protects the keys. It is read the first time an encrypted
+	// key is loaded, and keys written by zcert are encrypted with it when set.
+	passphrase []byte
+
+	// splitKey is the random key the keys are sealed under by Split. It is
+	// recovered from shares submitted over the unlock socket.
+	splitKey       []byte
+	splitThreshold int
+
+	// crls holds the last CRL signed for each issuer, by key id
+	crlLock sync.Mutex
+	crls    map[string]*signedCRL
+}
+
+type UnknownAuthorityError struct {
+	name string
+}
+
+type AuthorityRequiredError struct {
+	names []string
+}
+
+type InvalidAuthorityError struct {
+	name   string
+	reason string
+}
+
+func (e *UnknownAuthorityError) Error() string {
+	return fmt.Sprintf("no certificate authority named %q in cas", e.name)
+}
+
+func (e *AuthorityRequiredError) Error() string {
+	return fmt.Sprintf("several certificate authorities are configured, pick one of %s with --ca", strings.Join(e.names, ", "))
+}
+
+func (e *InvalidAuthorityError) Error() string {
+	return fmt.Sprintf("certificate authority %q is misconfigured: %s", e.name, e.reason)
+}
+
+// authority names end up in URL paths, and /ca/<name> is taken by the
+// authorities' certificates
+var authorityName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Names returns the names of the configured certificate authorities, or a
+// single empty name when cas isn't configured
+func Names() []string {
+	cas := viper.GetStringMap("cas")
+	if len(cas) == 0 {
+		return []string{""}
+	}
+
+	names := make([]string, 0, len(cas))
+	for name := range cas {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// New returns the named certificate authority without loading anything. An
+// empty name picks the only configured authority.
+func New(name string) (*Authority, error) {
+	names := Names()
+	if len(name) == 0 {
+		if len(names) > 1 {
+			return nil, &AuthorityRequiredError{names: names}
+		}
+
+		name = names[0]
+	}
+
+	conf := viper.New()
+	if err := conf.MergeConfigMap(viper.AllSettings()); err != nil {
+		return nil, err
+	}
+
+	if len(name) == 0 {
+		return &Authority{conf: conf}, nil
+	}
+
+	// viper keys are case insensitive
+	name = strings.ToLower(name)
+
+	section := viper.GetStringMap("cas." + name)
+	if len(section) == 0 {
+		return nil, &UnknownAuthorityError{name: name}
+	}
+
+	if !authorityName.MatchString(name) || name == "ca" {
+		return nil, &InvalidAuthorityError{name: name, reason: "names may only contain letters, digits, - and _, and can't be ca"}
+	}
+
+	if err := conf.MergeConfigMap(section); err != nil {
+		return nil, err
+	}
+
+	prefix := fmt.Sprintf("cas.%s.", name)
+	if !viper.IsSet(prefix + "storage.path") {
+		return nil, &InvalidAuthorityError{name: name, reason: "storage.path has to be set"}
+	}
+
+	if !viper.IsSet(prefix + "storage.database") {
+		conf.Set("storage.database", filepath.Join(conf.GetString("storage.path"), "db.sqlite3"))
+	}
+
+	return &Authority{Name: name, conf: conf}, nil
+}
+
+// Load returns the named certificate authority with its database opened and
+// its certificates & keys loaded
+func Load(name string) (*Authority, error) {
+	a, err := New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = a.OpenDB(); err != nil {
+		return nil, err
+	}
+
+	if err = a.LoadCA(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// LoadAll loads every configured certificate authority
+func LoadAll() ([]*Authority, error) {
+	var authorities []*Authority
+	for _, name := range Names() {
+		a, err := Load(name)
+		if err != nil {
+			return nil, err
+		}
+
+		authorities = append(authorities, a)
+	}
+
+	return authorities, nil
+}
+
+// Config returns the authority's settings
+func (a *Authority) Config() *viper.Viper {
+	return a.conf
+}
+
+// OpenDB opens the database in storage.database
+func (a *Authority) OpenDB() error {
+	conn, err := db.Open(a.conf.GetString("storage.database"), a.conf.GetDuration("storage.busy_timeout"))
+	if err != nil {
+		return err
+	}
+
+	a.DB = conn
+	return nil
+}
@@ -2,10 +2,11 @@ package certs
 
 import (
 	"bytes"
-	"crypto/ed25519"
+	"crypto"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -14,21 +15,25 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 	"github.com/stormentt/zcert/util"
 )
 
-var CA *x509.Certificate
-var CAPrivKey ed25519.PrivateKey
-
 type FileExistsError struct {
 	path string
 }
 
+type NotCAError struct {
+	path string
+}
+
 func (e *FileExistsError) Error() string {
 	return fmt.Sprintf("file %s already exists! will not procede without -f", e.path)
 }
 
+func (e *NotCAError) Error() string {
+	return fmt.Sprintf("certificate in %s is not a certificate authority", e.path)
+}
+
 func checkFile(f string, force bool) error {
 	if _, err := os.Stat(f); err == nil {
 		if !force {
@@ -44,23 +49,134 @@ func checkFile(f string, force bool) error {
 	return nil
 }
 
-func getPkix() pkix.Name {
-	/*
-		should support:
-			Country, Organization, OrganizationalUnit []string
-			Locality, Province                        []string
-			StreetAddress, PostalCode                 []string
-			SerialNumber, CommonName                  string
-	*/
+func (a *Authority) storagePath(name string) string {
+	return fmt.Sprintf("%s/%s", a.conf.GetString("storage.path"), name)
+}
 
-	return pkix.Name{
-		CommonName: viper.GetString("ca.name"),
+func (a *Authority) getPkix(name string) (pkix.Name, error) {
+	var attrs SubjectAttributes
+	if err := a.conf.UnmarshalKey("ca.subject", &attrs); err != nil {
+		return pkix.Name{}, err
 	}
+
+	return attrs.Name(name), nil
 }
 
-func CreateCA() error {
-	certDir := viper.GetString("storage.path")
-	force := viper.GetBool("force")
+func (a *Authority) caTemplate(name string, lifetime time.Duration) (*x509.Certificate, error) {
+	subject, err := a.getPkix(name)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               subject,
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(lifetime),
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+
+	if err := a.applyNameConstraints(template); err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+// CreateCA creates a self-signed certificate authority in ca.crt & ca.key,
+// used directly by the server to sign certificates
+func (a *Authority) CreateCA() error {
+	return a.createRoot(a.conf.GetString("ca.name"), "ca.crt", "ca.key")
+}
+
+// CreateRoot creates a self-signed root certificate authority in root.crt &
+// root.key. The root is only used to sign intermediates and should be kept
+// offline.
+func (a *Authority) CreateRoot() error {
+	name := a.conf.GetString("ca.root_name")
+	if len(name) == 0 {
+		name = a.conf.GetString("ca.name")
+	}
+
+	return a.createRoot(name, "root.crt", "root.key")
+}
+
+// CreateIntermediate creates an intermediate certificate authority signed by
+// the root in rootCrtPath & rootKeyPath. An empty rootKeyPath means root.key,
+// loaded from the signer backend. The intermediate is written to ca.crt
+// & ca.key and the root to chain.crt, which is everything the server needs.
+func (a *Authority) CreateIntermediate(rootCrtPath, rootKeyPath string, maxPathLen int) error {
+	root, err := util.DecodeX509CertFromPath(rootCrtPath)
+	if err != nil {
+		return err
+	}
+
+	if !root.IsCA {
+		return &NotCAError{path: rootCrtPath}
+	}
+
+	rootKey, err := a.loadRootKey(rootKeyPath)
+	if err != nil {
+		return err
+	}
+
+	template, err := a.caTemplate(a.conf.GetString("ca.name"), a.conf.GetDuration("lifetime"))
+	if err != nil {
+		return err
+	}
+
+	template.MaxPathLen = maxPathLen
+	template.MaxPathLenZero = maxPathLen == 0
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), randomSerialBits))
+	if err != nil {
+		return err
+	}
+
+	template.SerialNumber = serial
+
+	if template.NotAfter.After(root.NotAfter) {
+		log.WithFields(log.Fields{
+			"requested": template.NotAfter,
+			"root":      root.NotAfter,
+		}).Info("capping intermediate lifetime at the root's expiry")
+
+		template.NotAfter = root.NotAfter
+	}
+
+	chainBuf := new(bytes.Buffer)
+	if err = util.EncodeX509Cert(chainBuf, root.Raw); err != nil {
+		return err
+	}
+
+	if err = checkFile(a.storagePath("chain.crt"), a.conf.GetBool("force")); err != nil {
+		return err
+	}
+
+	if err = a.createAuthority(template, root, rootKey, "ca.crt", "ca.key"); err != nil {
+		return err
+	}
+
+	return writeFile(a.storagePath("chain.crt"), chainBuf, 0644)
+}
+
+func (a *Authority) createRoot(name, crtFile, keyFile string) error {
+	template, err := a.caTemplate(name, a.conf.GetDuration("lifetime"))
+	if err != nil {
+		return err
+	}
+
+	return a.createAuthority(template, nil, nil, crtFile, keyFile)
+}
+
+// createAuthority generates a key for template and signs it with parentKey,
+// or self-signs it when parent is nil
+func (a *Authority) createAuthority(template, parent *x509.Certificate, parentKey crypto.Signer, crtFile, keyFile string) error {
+	certDir := a.conf.GetString("storage.path")
+	force := a.conf.GetBool("force")
 
 	if _, err := os.Stat(certDir); errors.Is(err, os.ErrNotExist) {
 		err := os.MkdirAll(certDir, 0700)
@@ -69,12 +185,13 @@ func CreateCA() error {
 		}
 	}
 
-	caKeyPath := fmt.Sprintf("%s/%s", certDir, "ca.key")
-	caCertPath := fmt.Sprintf("%s/%s", certDir, "ca.crt")
+	caKeyPath := a.storagePath(keyFile)
+	caCertPath := a.storagePath(crtFile)
 
 	log.WithFields(log.Fields{
 		"storage.path": certDir,
 		"force":        force,
+		"subject":      template.Subject.String(),
 	}).Debug("creating a certificate authority")
 
 	checkFiles := []string{caKeyPath, caCertPath}
@@ -85,87 +202,139 @@ func CreateCA() error {
 		}
 	}
 
-	ca := &x509.Certificate{
-		SerialNumber:          big.NewInt(1),
-		Subject:               getPkix(),
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(viper.GetDuration("lifetime")),
-		IsCA:                  true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		BasicConstraintsValid: true,
+	b, err := a.Backend()
+	if err != nil {
+		return err
 	}
 
-	pubkey, privkey, err := ed25519.GenerateKey(nil)
+	key, err := b.GenerateKey(keyFile, a.conf.GetString("ca.keytype"))
 	if err != nil {
-		return nil
+		return err
 	}
 
-	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, pubkey, privkey)
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	caBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
 	if err != nil {
 		return err
 	}
 
 	crtBuf := new(bytes.Buffer)
-	keyBuf := new(bytes.Buffer)
-
-	// Encode Keys
 	if err = util.EncodeX509Cert(crtBuf, caBytes); err != nil {
 		return err
 	}
 
-	if err = util.EncodeEd25519Priv(keyBuf, privkey); err != nil {
-		return err
+	return writeFile(caCertPath, crtBuf, 0644)
+}
+
+// loadRootKey loads the root's key from path, or from the signer backend when
+// path is empty. A root key file has its own passphrase, separate from the
+// one protecting the keys zcert creates.
+func (a *Authority) loadRootKey(path string) (crypto.Signer, error) {
+	if len(path) == 0 && a.conf.GetString("ca.signer") != SignerFile {
+		return a.loadKey("root.key")
+	}
+
+	if len(path) == 0 {
+		path = a.storagePath("root.key")
 	}
 
-	// Create Files
-	keyout, err := os.OpenFile(caKeyPath, os.O_RDWR|os.O_CREATE, 0600)
+	key, err := util.DecodePrivateKey(path, &util.KeySecrets{Passphrase: a.rootPassphrase})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	certout, err := os.OpenFile(caCertPath, os.O_RDWR|os.O_CREATE, 0644)
+	return key, nil
+}
+
+func writeFile(path string, r io.Reader, perm os.FileMode) error {
+	out, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, perm)
 	if err != nil {
 		return err
 	}
 
-	// Write Files
-	if _, err = io.Copy(keyout, keyBuf); err != nil {
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
 		return err
 	}
 
-	if _, err = io.Copy(certout, crtBuf); err != nil {
+	return out.Close()
+}
+
+// LoadCA loads the authority's certificate, key, chain and retired
+// certificate authorities
+func (a *Authority) LoadCA() error {
+	caCrtPath := a.storagePath("ca.crt")
+	chainPath := a.storagePath("chain.crt")
+
+	var err error
+
+	a.Cert, err = util.DecodeX509CertFromPath(caCrtPath)
+	if err != nil {
 		return err
 	}
 
-	// Close Files
-	if err = keyout.Close(); err != nil {
+	a.Signer, err = a.loadKey("ca.key")
+	if err != nil {
 		return err
 	}
 
-	if err = certout.Close(); err != nil {
+	if err = checkKey("ca.key", a.Signer, a.Cert); err != nil {
 		return err
 	}
 
-	return nil
+	a.Chain = nil
+	if _, err = os.Stat(chainPath); err == nil {
+		a.Chain, err = util.DecodeX509CertsFromPath(chainPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = a.loadRetired(); err != nil {
+		return err
+	}
+
+	return a.loadSSH()
 }
 
-func LoadCA() error {
-	certDir := viper.GetString("storage.path")
-	caCrtPath := fmt.Sprintf("%s/%s", certDir, "ca.crt")
-	caKeyPath := fmt.Sprintf("%s/%s", certDir, "ca.key")
+// KeyID returns the hex encoded subject key id of a certificate authority
+func KeyID(crt *x509.Certificate) string {
+	return hex.EncodeToString(crt.SubjectKeyId)
+}
 
-	var err error
+func isSelfSigned(crt *x509.Certificate) bool {
+	return bytes.Equal(crt.RawIssuer, crt.RawSubject) && crt.CheckSignatureFrom(crt) == nil
+}
 
-	CA, err = util.DecodeX509CertFromPath(caCrtPath)
-	if err != nil {
-		return err
+// IssuerChain returns the certificates that should be sent along with a
+// certificate issued by the authority: its certificate and any intermediates
+// above it, but not the root. After a rollover the cross-signed certificates
+// linking it back to the retired roots are included too, for clients that
+// only trust those.
+func (a *Authority) IssuerChain() []*x509.Certificate {
+	var chain []*x509.Certificate
+	if !isSelfSigned(a.Cert) {
+		chain = append(chain, a.Cert)
+		for _, crt := range a.Chain {
+			if !isSelfSigned(crt) {
+				chain = append(chain, crt)
+			}
+		}
 	}
 
-	CAPrivKey, err = util.DecodeEd25519Priv(caKeyPath)
-	if err != nil {
-		return err
+	for _, retired := range a.Retired {
+		chain = append(chain, retired.NewByOld)
 	}
 
-	return nil
+	return chain
+}
+
+// TrustChain returns the authority's certificate followed by every
+// certificate above it
+func (a *Authority) TrustChain() []*x509.Certificate {
+	return append([]*x509.Certificate{a.Cert}, a.Chain...)
 }
@@ -0,0 +1,289 @@
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+)
+
+type NameConstraintError struct {
+	kind   string
+	name   string
+	issuer string
+}
+
+func (e *NameConstraintError) Error() string {
+	return fmt.Sprintf("%s %s is outside the name constraints of %s", e.kind, e.name, e.issuer)
+}
+
+type UnconstrainedSubordinateError struct {
+	kind   string
+	issuer string
+}
+
+func (e *UnconstrainedSubordinateError) Error() string {
+	return fmt.Sprintf("%s constrains %s, so subordinate certificate authorities need permitted %s too", e.issuer, e.kind, e.kind)
+}
+
+func (e *NameConstraintError) PolicyViolation()           {}
+func (e *UnconstrainedSubordinateError) PolicyViolation() {}
+
+// applyNameConstraints copies the ca.constraints configuration into template
+func (a *Authority) applyNameConstraints(template *x509.Certificate) error {
+	permittedIPs, err := parseIPRanges(a.conf.GetStringSlice("ca.constraints.permitted_ip_ranges"))
+	if err != nil {
+		return err
+	}
+
+	excludedIPs, err := parseIPRanges(a.conf.GetStringSlice("ca.constraints.excluded_ip_ranges"))
+	if err != nil {
+		return err
+	}
+
+	template.PermittedDNSDomains = a.conf.GetStringSlice("ca.constraints.permitted_dns_domains")
+	template.ExcludedDNSDomains = a.conf.GetStringSlice("ca.constraints.excluded_dns_domains")
+	template.PermittedIPRanges = permittedIPs
+	template.ExcludedIPRanges = excludedIPs
+	template.PermittedEmailAddresses = a.conf.GetStringSlice("ca.constraints.permitted_email_domains")
+	template.ExcludedEmailAddresses = a.conf.GetStringSlice("ca.constraints.excluded_email_domains")
+	template.PermittedURIDomains = a.conf.GetStringSlice("ca.constraints.permitted_uri_domains")
+	template.ExcludedURIDomains = a.conf.GetStringSlice("ca.constraints.excluded_uri_domains")
+
+	template.PermittedDNSDomainsCritical = hasNameConstraints(template)
+	return nil
+}
+
+func hasNameConstraints(crt *x509.Certificate) bool {
+	return len(crt.PermittedDNSDomains) > 0 || len(crt.ExcludedDNSDomains) > 0 ||
+		len(crt.PermittedIPRanges) > 0 || len(crt.ExcludedIPRanges) > 0 ||
+		len(crt.PermittedEmailAddresses) > 0 || len(crt.ExcludedEmailAddresses) > 0 ||
+		len(crt.PermittedURIDomains) > 0 || len(crt.ExcludedURIDomains) > 0
+}
+
+// matchDomain follows RFC 5280: a constraint starting with a dot only matches
+// subdomains, otherwise it matches the domain itself and its subdomains
+func matchDomain(domain, constraint string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	constraint = strings.ToLower(constraint)
+
+	if len(constraint) == 0 {
+		return true
+	}
+
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(domain, constraint)
+	}
+
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+// matchEmail matches a mailbox against a constraint that is either a whole
+// mailbox, a host, or a domain starting with a dot
+func matchEmail(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	host := email[at+1:]
+	if strings.HasPrefix(constraint, ".") {
+		return matchDomain(host, constraint)
+	}
+
+	return strings.EqualFold(host, constraint)
+}
+
+// matchURIHost matches the host of a URI. Unlike DNS constraints, a constraint
+// without a leading dot only matches that exact host.
+func matchURIHost(host, constraint string) bool {
+	if strings.HasPrefix(constraint, ".") {
+		return matchDomain(host, constraint)
+	}
+
+	return strings.EqualFold(host, constraint)
+}
+
+func permitted(name string, permitted, excluded []string, match func(string, string) bool) bool {
+	for _, constraint := range excluded {
+		if match(name, constraint) {
+			return false
+		}
+	}
+
+	if len(permitted) == 0 {
+		return true
+	}
+
+	for _, constraint := range permitted {
+		if match(name, constraint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func permittedIP(ip net.IP, permitted, excluded []*net.IPNet) bool {
+	for _, ipnet := range excluded {
+		if ipnet.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(permitted) == 0 {
+		return true
+	}
+
+	for _, ipnet := range permitted {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withinRange reports whether the whole of inner lies inside outer
+func withinRange(inner, outer *net.IPNet) bool {
+	innerOnes, innerBits := inner.Mask.Size()
+	outerOnes, outerBits := outer.Mask.Size()
+
+	return innerBits == outerBits && innerOnes >= outerOnes && outer.Contains(inner.IP)
+}
+
+// checkSubordinateConstraints makes sure the dns and ip constraints of a
+// subordinate certificate authority stay within those of issuer. A
+// subordinate without constraints of a kind the issuer constrains is refused,
+// since it would claim names the issuer may not vouch for.
+func checkSubordinateConstraints(crt, issuer *x509.Certificate) error {
+	issuerName := issuer.Subject.String()
+
+	if len(issuer.PermittedDNSDomains) > 0 && len(crt.PermittedDNSDomains) == 0 {
+		return &UnconstrainedSubordinateError{kind: "dns domains", issuer: issuerName}
+	}
+
+	for _, domains := range [][]string{crt.PermittedDNSDomains, crt.ExcludedDNSDomains} {
+		for _, domain := range domains {
+			if !permitted(domain, issuer.PermittedDNSDomains, issuer.ExcludedDNSDomains, matchDomain) {
+				return &NameConstraintError{kind: "dns domain constraint", name: domain, issuer: issuerName}
+			}
+		}
+	}
+
+	if len(issuer.PermittedIPRanges) > 0 && len(crt.PermittedIPRanges) == 0 {
+		return &UnconstrainedSubordinateError{kind: "ip ranges", issuer: issuerName}
+	}
+
+	for _, ranges := range [][]*net.IPNet{crt.PermittedIPRanges, crt.ExcludedIPRanges} {
+		for _, ipnet := range ranges {
+			allowed := len(issuer.PermittedIPRanges) == 0
+			for _, outer := range issuer.PermittedIPRanges {
+				allowed = allowed || withinRange(ipnet, outer)
+			}
+
+			for _, outer := range issuer.ExcludedIPRanges {
+				allowed = allowed && !withinRange(ipnet, outer)
+			}
+
+			if !allowed {
+				return &NameConstraintError{kind: "ip range constraint", name: ipnet.String(), issuer: issuerName}
+			}
+		}
+	}
+
+	return nil
+}
+
+// hostnameLike reports whether a common name looks like a dns name, which
+// verifiers that still match on the common name would accept for that host
+func hostnameLike(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if !strings.Contains(name, ".") {
+		return false
+	}
+
+	for i, label := range strings.Split(name, ".") {
+		if len(label) == 0 {
+			return false
+		}
+
+		if i == 0 && label == "*" {
+			continue
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// checkNameConstraints makes sure every subject alternative name in crt is
+// allowed by the name constraints of the authority and the certificates above
+// it, so that zcert never issues a certificate that clients would reject. A
+// leaf's common name is held to the same constraints when it looks like a
+// host name or an ip address. The constraints of a subordinate certificate
+// authority have to fit within them too.
+func (a *Authority) checkNameConstraints(crt *x509.Certificate) error {
+	for _, issuer := range a.TrustChain() {
+		if !hasNameConstraints(issuer) {
+			continue
+		}
+
+		issuerName := issuer.Subject.String()
+
+		if crt.IsCA {
+			if err := checkSubordinateConstraints(crt, issuer); err != nil {
+				return err
+			}
+		}
+
+		dnsNames, ips := crt.DNSNames, crt.IPAddresses
+		if cn := crt.Subject.CommonName; !crt.IsCA {
+			if ip := net.ParseIP(cn); ip != nil {
+				ips = append([]net.IP{ip}, ips...)
+			} else if hostnameLike(cn) {
+				dnsNames = append([]string{cn}, dnsNames...)
+			}
+		}
+
+		for _, name := range dnsNames {
+			if !permitted(name, issuer.PermittedDNSDomains, issuer.ExcludedDNSDomains, matchDomain) {
+				return &NameConstraintError{kind: "dns name", name: name, issuer: issuerName}
+			}
+		}
+
+		for _, ip := range ips {
+			if !permittedIP(ip, issuer.PermittedIPRanges, issuer.ExcludedIPRanges) {
+				return &NameConstraintError{kind: "ip address", name: ip.String(), issuer: issuerName}
+			}
+		}
+
+		for _, email := range crt.EmailAddresses {
+			if !permitted(email, issuer.PermittedEmailAddresses, issuer.ExcludedEmailAddresses, matchEmail) {
+				return &NameConstraintError{kind: "email address", name: email, issuer: issuerName}
+			}
+		}
+
+		for _, uri := range crt.URIs {
+			host := uri.Hostname()
+			if len(host) == 0 && (len(issuer.PermittedURIDomains) > 0 || len(issuer.ExcludedURIDomains) > 0) {
+				return &NameConstraintError{kind: "uri", name: uri.String(), issuer: issuerName}
+			}
+
+			if !permitted(host, issuer.PermittedURIDomains, issuer.ExcludedURIDomains, matchURIHost) {
+				return &NameConstraintError{kind: "uri", name: uri.String(), issuer: issuerName}
+			}
+		}
+	}
+
+	return nil
+}
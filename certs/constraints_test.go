@@ -0,0 +1,260 @@
+package certs
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ipnet
+}
+
+// constrainedAuthority returns an authority whose certificate permits
+// example.com and 10.0.0.0/8 but excludes secret.example.com
+func constrainedAuthority(t *testing.T) *Authority {
+	return &Authority{Cert: &x509.Certificate{
+		Subject:             pkix.Name{CommonName: "constrained ca"},
+		IsCA:                true,
+		PermittedDNSDomains: []string{"example.com"},
+		ExcludedDNSDomains:  []string{"secret.example.com"},
+		PermittedIPRanges:   []*net.IPNet{mustCIDR(t, "10.0.0.0/8")},
+	}}
+}
+
+func TestCommonNameConstraints(t *testing.T) {
+	a := constrainedAuthority(t)
+
+	for _, test := range []struct {
+		cn      string
+		sans    []string
+		allowed bool
+	}{
+		{cn: "www.example.com", allowed: true},
+		{cn: "www.example.com", sans: []string{"www.example.com"}, allowed: true},
+		{cn: "*.example.com", allowed: true},
+		{cn: "www.evil.com", allowed: false},
+		{cn: "www.evil.com", sans: []string{"www.example.com"}, allowed: false},
+		{cn: "*.evil.com", allowed: false},
+		{cn: "db.secret.example.com", allowed: false},
+		{cn: "WWW.EVIL.COM.", allowed: false},
+		{cn: "10.1.2.3", allowed: true},
+		{cn: "192.168.1.1", allowed: false},
+		{cn: "Jane Doe", allowed: true},
+		{cn: "webserver", allowed: true},
+		{cn: "", sans: []string{"www.example.com"}, allowed: true},
+		{cn: "", sans: []string{"www.evil.com"}, allowed: false},
+	} {
+		crt := &x509.Certificate{
+			Subject:  pkix.Name{CommonName: test.cn},
+			DNSNames: test.sans,
+		}
+
+		err := a.checkNameConstraints(crt)
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("common name %q with sans %v: allowed %t, expected %t (%v)", test.cn, test.sans, allowed, test.allowed, err)
+		}
+	}
+}
+
+func TestMatchDomain(t *testing.T) {
+	for _, test := range []struct {
+		domain     string
+		constraint string
+		match      bool
+	}{
+		{domain: "example.com", constraint: "example.com", match: true},
+		{domain: "www.example.com", constraint: "example.com", match: true},
+		{domain: "a.b.example.com", constraint: "example.com", match: true},
+		{domain: "WWW.Example.COM", constraint: "example.com", match: true},
+		{domain: "www.example.com.", constraint: "example.com", match: true},
+		{domain: "badexample.com", constraint: "example.com", match: false},
+		{domain: "example.com.evil.org", constraint: "example.com", match: false},
+		{domain: "example.com", constraint: ".example.com", match: false},
+		{domain: "www.example.com", constraint: ".example.com", match: true},
+		{domain: "anything.org", constraint: "", match: true},
+	} {
+		if match := matchDomain(test.domain, test.constraint); match != test.match {
+			t.Errorf("%q against %q: matched %t, expected %t", test.domain, test.constraint, match, test.match)
+		}
+	}
+}
+
+func TestMatchEmail(t *testing.T) {
+	for _, test := range []struct {
+		email      string
+		constraint string
+		match      bool
+	}{
+		{email: "jane@example.com", constraint: "jane@example.com", match: true},
+		{email: "JANE@example.com", constraint: "jane@example.com", match: true},
+		{email: "john@example.com", constraint: "jane@example.com", match: false},
+		{email: "jane@example.com", constraint: "example.com", match: true},
+		{email: "jane@mail.example.com", constraint: "example.com", match: false},
+		{email: "jane@mail.example.com", constraint: ".example.com", match: true},
+		{email: "jane@example.com", constraint: ".example.com", match: false},
+		{email: "example.com", constraint: "example.com", match: false},
+	} {
+		if match := matchEmail(test.email, test.constraint); match != test.match {
+			t.Errorf("%q against %q: matched %t, expected %t", test.email, test.constraint, match, test.match)
+		}
+	}
+}
+
+func TestMatchURIHost(t *testing.T) {
+	for _, test := range []struct {
+		host       string
+		constraint string
+		match      bool
+	}{
+		{host: "example.com", constraint: "example.com", match: true},
+		{host: "EXAMPLE.com", constraint: "example.com", match: true},
+		{host: "www.example.com", constraint: "example.com", match: false},
+		{host: "www.example.com", constraint: ".example.com", match: true},
+		{host: "example.com", constraint: ".example.com", match: false},
+	} {
+		if match := matchURIHost(test.host, test.constraint); match != test.match {
+			t.Errorf("%q against %q: matched %t, expected %t", test.host, test.constraint, match, test.match)
+		}
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		permitted []string
+		excluded  []string
+		allowed   bool
+	}{
+		{name: "www.example.com", allowed: true},
+		{name: "www.example.com", permitted: []string{"example.com"}, allowed: true},
+		{name: "www.example.org", permitted: []string{"example.com"}, allowed: false},
+		{name: "www.example.org", permitted: []string{"example.com", "example.org"}, allowed: true},
+		{name: "www.example.com", excluded: []string{"example.com"}, allowed: false},
+		{name: "www.example.org", excluded: []string{"example.com"}, allowed: true},
+		{name: "db.secret.example.com", permitted: []string{"example.com"}, excluded: []string{"secret.example.com"}, allowed: false},
+		{name: "secret.example.com", permitted: []string{"secret.example.com"}, excluded: []string{"secret.example.com"}, allowed: false},
+	} {
+		if allowed := permitted(test.name, test.permitted, test.excluded, matchDomain); allowed != test.allowed {
+			t.Errorf("%q permitting %v excluding %v: allowed %t, expected %t", test.name, test.permitted, test.excluded, allowed, test.allowed)
+		}
+	}
+}
+
+func TestPermittedIP(t *testing.T) {
+	for _, test := range []struct {
+		ip        string
+		permitted []string
+		excluded  []string
+		allowed   bool
+	}{
+		{ip: "10.1.2.3", allowed: true},
+		{ip: "10.1.2.3", permitted: []string{"10.0.0.0/8"}, allowed: true},
+		{ip: "11.1.2.3", permitted: []string{"10.0.0.0/8"}, allowed: false},
+		{ip: "10.255.255.255", permitted: []string{"10.0.0.0/8"}, allowed: true},
+		{ip: "10.1.2.3", permitted: []string{"10.0.0.0/8"}, excluded: []string{"10.1.0.0/16"}, allowed: false},
+		{ip: "10.2.2.3", permitted: []string{"10.0.0.0/8"}, excluded: []string{"10.1.0.0/16"}, allowed: true},
+		{ip: "::ffff:10.1.2.3", permitted: []string{"10.0.0.0/8"}, allowed: true},
+		{ip: "fd00::1", permitted: []string{"10.0.0.0/8"}, allowed: false},
+		{ip: "fd00::1", permitted: []string{"fd00::/8"}, allowed: true},
+	} {
+		var permittedRanges, excludedRanges []*net.IPNet
+		for _, s := range test.permitted {
+			permittedRanges = append(permittedRanges, mustCIDR(t, s))
+		}
+
+		for _, s := range test.excluded {
+			excludedRanges = append(excludedRanges, mustCIDR(t, s))
+		}
+
+		if allowed := permittedIP(net.ParseIP(test.ip), permittedRanges, excludedRanges); allowed != test.allowed {
+			t.Errorf("%s permitting %v excluding %v: allowed %t, expected %t", test.ip, test.permitted, test.excluded, allowed, test.allowed)
+		}
+	}
+}
+
+func TestWithinRange(t *testing.T) {
+	for _, test := range []struct {
+		inner  string
+		outer  string
+		within bool
+	}{
+		{inner: "10.1.0.0/16", outer: "10.0.0.0/8", within: true},
+		{inner: "10.0.0.0/8", outer: "10.0.0.0/8", within: true},
+		{inner: "10.0.0.0/7", outer: "10.0.0.0/8", within: false},
+		{inner: "11.0.0.0/16", outer: "10.0.0.0/8", within: false},
+		{inner: "fd00:1::/32", outer: "fd00::/8", within: true},
+		{inner: "fd00::/8", outer: "10.0.0.0/8", within: false},
+	} {
+		if within := withinRange(mustCIDR(t, test.inner), mustCIDR(t, test.outer)); within != test.within {
+			t.Errorf("%s within %s: %t, expected %t", test.inner, test.outer, within, test.within)
+		}
+	}
+}
+
+func TestNameConstraintsSANs(t *testing.T) {
+	a := constrainedAuthority(t)
+	a.Cert.PermittedEmailAddresses = []string{".example.com"}
+	a.Cert.PermittedURIDomains = []string{".example.com"}
+
+	for _, test := range []struct {
+		name    string
+		crt     *x509.Certificate
+		allowed bool
+	}{
+		{name: "permitted ip", crt: &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}, allowed: true},
+		{name: "ip outside", crt: &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}}, allowed: false},
+		{name: "permitted email", crt: &x509.Certificate{EmailAddresses: []string{"jane@mail.example.com"}}, allowed: true},
+		{name: "email outside", crt: &x509.Certificate{EmailAddresses: []string{"jane@evil.com"}}, allowed: false},
+		{name: "permitted uri", crt: &x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://www.example.com/web")}}, allowed: true},
+		{name: "uri outside", crt: &x509.Certificate{URIs: []*url.URL{mustURL(t, "https://evil.com/")}}, allowed: false},
+		{name: "uri without host", crt: &x509.Certificate{URIs: []*url.URL{mustURL(t, "urn:example:web")}}, allowed: false},
+	} {
+		err := a.checkNameConstraints(test.crt)
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("%s: allowed %t, expected %t (%v)", test.name, allowed, test.allowed, err)
+		}
+	}
+}
+
+func mustURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func TestSubordinateConstraints(t *testing.T) {
+	a := constrainedAuthority(t)
+
+	for _, test := range []struct {
+		name    string
+		crt     *x509.Certificate
+		allowed bool
+	}{
+		{name: "narrower", crt: &x509.Certificate{PermittedDNSDomains: []string{"www.example.com"}, PermittedIPRanges: []*net.IPNet{mustCIDR(t, "10.1.0.0/16")}}, allowed: true},
+		{name: "same", crt: &x509.Certificate{PermittedDNSDomains: []string{"example.com"}, PermittedIPRanges: []*net.IPNet{mustCIDR(t, "10.0.0.0/8")}}, allowed: true},
+		{name: "unconstrained", crt: &x509.Certificate{}, allowed: false},
+		{name: "no ip ranges", crt: &x509.Certificate{PermittedDNSDomains: []string{"example.com"}}, allowed: false},
+		{name: "other domain", crt: &x509.Certificate{PermittedDNSDomains: []string{"evil.com"}, PermittedIPRanges: []*net.IPNet{mustCIDR(t, "10.0.0.0/8")}}, allowed: false},
+		{name: "excluded domain", crt: &x509.Certificate{PermittedDNSDomains: []string{"secret.example.com"}, PermittedIPRanges: []*net.IPNet{mustCIDR(t, "10.0.0.0/8")}}, allowed: false},
+		{name: "wider range", crt: &x509.Certificate{PermittedDNSDomains: []string{"example.com"}, PermittedIPRanges: []*net.IPNet{mustCIDR(t, "10.0.0.0/7")}}, allowed: false},
+	} {
+		test.crt.IsCA = true
+		test.crt.Subject = pkix.Name{CommonName: "sub ca"}
+
+		err := a.checkNameConstraints(test.crt)
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("%s: allowed %t, expected %t (%v)", test.name, allowed, test.allowed, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/stormentt/zcert/db"
+)
+
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+type UnknownIssuerError struct {
+	keyID string
+}
+
+type InvalidSerialError struct {
+	serial string
+}
+
+type NoCRLSignError struct {
+	keyID string
+}
+
+func (e *UnknownIssuerError) Error() string {
+	return fmt.Sprintf("no certificate authority with key id %s", e.keyID)
+}
+
+func (e *InvalidSerialError) Error() string {
+	return fmt.Sprintf("invalid serial %q in database", e.serial)
+}
+
+func (e *NoCRLSignError) Error() string {
+	return fmt.Sprintf("certificate authority %s lacks the crl signing key usage, re-issue it with zcert ca renew", e.keyID)
+}
+
+// canSignCRLs reports whether crt's key usage allows signing CRLs. A
+// certificate without key usage may be used for anything.
+func canSignCRLs(crt *x509.Certificate) bool {
+	return crt.KeyUsage == 0 || crt.KeyUsage&x509.KeyUsageCRLSign != 0
+}
+
+// Issuer returns the certificate & key of the current or a retired certificate
+// authority by its hex encoded subject key id
+func (a *Authority) Issuer(keyID string) (*x509.Certificate, crypto.Signer, error) {
+	if keyID == KeyID(a.Cert) {
+		return a.Cert, a.Signer, nil
+	}
+
+	for _, retired := range a.Retired {
+		if keyID == KeyID(retired.Cert) {
+			return retired.Cert, retired.Key, nil
+		}
+	}
+
+	return nil, nil, &UnknownIssuerError{keyID: keyID}
+}
+
+// signedCRL is a CRL kept to answer requests with until it's refreshed
+type signedCRL struct {
+	der     []byte
+	revoked int64
+	refresh time.Time
+}
+
+// CRL returns the DER encoded CRL of the current or a retired certificate
+// authority by its hex encoded subject key id. A CRL is signed once and
+// served until half its lifetime has passed or another of its issuer's
+// certificates is revoked, so anonymous requests don't each cost a signature.
+func (a *Authority) CRL(keyID string) ([]byte, error) {
+	crt, key, err := a.Issuer(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	a.crlLock.Lock()
+	defer a.crlLock.Unlock()
+
+	revoked, err := db.RevokedCount(a.DB, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := a.crls[keyID]; ok && cached.revoked == revoked && time.Now().Before(cached.refresh) {
+		return cached.der, nil
+	}
+
+	der, err := a.CreateCRL(crt, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.crls == nil {
+		a.crls = map[string]*signedCRL{}
+	}
+
+	a.crls[keyID] = &signedCRL{
+		der:     der,
+		revoked: revoked,
+		refresh: time.Now().Add(a.conf.GetDuration("crl.lifetime") / 2),
+	}
+
+	return der, nil
+}
+
+// CreateCRL returns a DER encoded CRL listing the unexpired revoked
+// certificates issued by crt, signed with key
+func (a *Authority) CreateCRL(crt *x509.Certificate, key crypto.Signer) ([]byte, error) {
+	if !canSignCRLs(crt) {
+		return nil, &NoCRLSignError{keyID: KeyID(crt)}
+	}
+
+	revoked, err := db.RevokedBy(a.DB, KeyID(crt))
+	if err != nil {
+		return nil, err
+	}
+
+	number, err := db.NextCRLNumber(a.DB, KeyID(crt))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: now,
+		NextUpdate: now.Add(a.conf.GetDuration("crl.lifetime")),
+	}
+
+	for _, sc := range revoked {
+		serial, ok := new(big.Int).SetString(sc.Serial, 16)
+		if !ok {
+			return nil, &InvalidSerialError{serial: sc.Serial}
+		}
+
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: sc.RevokedAt,
+		}
+
+		if code, ok := db.ReasonCodes[sc.RevocationReason]; ok && code != 0 {
+			reason, err := asn1.Marshal(asn1.Enumerated(code))
+			if err != nil {
+				return nil, err
+			}
+
+			entry.Extensions = append(entry.Extensions, pkix.Extension{
+				Id:    oidExtensionReasonCode,
+				Value: reason,
+			})
+		}
+
+		template.RevokedCertificates = append(template.RevokedCertificates, entry)
+	}
+
+	return x509.CreateRevocationList(rand.Reader, template, crt, key)
+}
@@ -0,0 +1,112 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+func crlAuthority(t *testing.T) *Authority {
+	key, crt := testCACert(t)
+
+	conf := viper.New()
+	conf.Set("crl.lifetime", time.Hour)
+
+	return testAuthority(t, conf, filepath.Join(t.TempDir(), "zcert.sqlite3"), key, crt)
+}
+
+func parseCRL(t *testing.T, der []byte) *x509.RevocationList {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crl
+}
+
+func TestCRLNumbers(t *testing.T) {
+	a := crlAuthority(t)
+
+	var previous *x509.RevocationList
+	for i := 0; i < 5; i++ {
+		der, err := a.CreateCRL(a.Cert, a.Signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		crl := parseCRL(t, der)
+		if previous != nil && crl.Number.Cmp(previous.Number) <= 0 {
+			t.Fatalf("crl number %s follows %s", crl.Number, previous.Number)
+		}
+
+		previous = crl
+	}
+
+	// numbers used to be the time the crl was made, so they have to stay
+	// above that
+	if previous.Number.Int64() < time.Now().Unix() {
+		t.Fatalf("crl number %s is below the current time", previous.Number)
+	}
+}
+
+func TestCRLCache(t *testing.T) {
+	a := crlAuthority(t)
+	keyID := KeyID(a.Cert)
+
+	first, err := a.CRL(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := a.CRL(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Fatal("crl was signed again without a revocation")
+	}
+
+	signed, err := a.SignCSR(testCSR(t, "www.example.com"), CSRParams{Lifetime: time.Hour, ServerAuth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := util.DecodeX509Cert(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := db.CertBySerial(a.DB, SerialString(leaf.SerialNumber))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = record.Revoke(a.DB, db.ReasonKeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := a.CRL(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl := parseCRL(t, third)
+	if len(crl.RevokedCertificates) != 1 || crl.RevokedCertificates[0].SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Fatal("crl served after a revocation doesn't list the revoked certificate")
+	}
+
+	if crl.Number.Cmp(parseCRL(t, first).Number) <= 0 {
+		t.Fatalf("crl number %s didn't increase after a revocation", crl.Number)
+	}
+
+	if _, err = a.CRL("00"); err == nil {
+		t.Fatal("served a crl for an unknown issuer")
+	}
+}
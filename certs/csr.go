@@ -4,17 +4,19 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/x509"
-	"math/big"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/stormentt/zcert/db"
 	"github.com/stormentt/zcert/util"
+	"gorm.io/gorm"
 )
 
 type CSRParams struct {
 	Lifetime   time.Duration
 	ClientAuth bool
 	ServerAuth bool
+	Profile    string
 }
 
 func ParseCSR(bytesB64 string) (*x509.CertificateRequest, error) {
@@ -26,11 +28,36 @@ func ParseCSR(bytesB64 string) (*x509.CertificateRequest, error) {
 	return x509.ParseCertificateRequest(data)
 }
 
-func ValidateCSR(csr *x509.CertificateRequest) error {
-	return csr.CheckSignature()
+type InvalidSignatureError struct {
+	err error
 }
 
-func SignCSR(csr *x509.CertificateRequest, params CSRParams) ([]byte, error) {
+func (e *InvalidSignatureError) Error() string {
+	return "csr signature invalid"
+}
+
+func (e *InvalidSignatureError) Unwrap() error {
+	return e.err
+}
+
+func (e *InvalidSignatureError) PolicyViolation() {}
+
+// ValidateCSR checks the CSR's self-signature and that its public key is
+// acceptable under the configured key policy
+func (a *Authority) ValidateCSR(csr *x509.CertificateRequest) error {
+	if err := csr.CheckSignature(); err != nil {
+		return &InvalidSignatureError{err: err}
+	}
+
+	return a.CheckKeyPolicy(csr.PublicKey)
+}
+
+func (a *Authority) SignCSR(csr *x509.CertificateRequest, params CSRParams) ([]byte, error) {
+	profile, err := a.LoadProfile(params.Profile)
+	if err != nil {
+		return nil, err
+	}
+
 	extKeyUsage := []x509.ExtKeyUsage{}
 	if params.ClientAuth {
 		extKeyUsage = append(extKeyUsage, x509.ExtKeyUsageClientAuth)
@@ -40,40 +67,136 @@ func SignCSR(csr *x509.CertificateRequest, params CSRParams) ([]byte, error) {
 		extKeyUsage = append(extKeyUsage, x509.ExtKeyUsageServerAuth)
 	}
 
-	serial := db.NextSerial()
-
 	crt := &x509.Certificate{
-		SerialNumber: big.NewInt(serial),
-		Subject:      csr.Subject,
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().Add(params.Lifetime),
-		IsCA:         false,
-		ExtKeyUsage:  extKeyUsage,
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		Subject:     csr.Subject,
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().Add(params.Lifetime),
+		IsCA:        false,
+		ExtKeyUsage: extKeyUsage,
+		KeyUsage:    leafKeyUsage(csr.PublicKey),
+
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+	}
+
+	return a.issue(csr, crt, profile, params)
+}
+
+// issue signs crt for the CSR's public key. Policy checks, serial
+// reservation, signing and recording the certificate all happen in a single
+// database transaction, so concurrent issuers never hand out the same serial
+// and a failure leaves nothing behind.
+func (a *Authority) issue(csr *x509.CertificateRequest, crt *x509.Certificate, profile *Profile, params CSRParams) ([]byte, error) {
+	crt.Subject = profile.Subject.Apply(crt.Subject)
+
+	if err := a.checkNameConstraints(crt); err != nil {
+		return nil, err
 	}
 
-	crtBytes, err := x509.CreateCertificate(rand.Reader, crt, CA, csr.PublicKey, CAPrivKey)
+	fingerprint, err := SPKIFingerprint(csr.PublicKey)
 	if err != nil {
 		return nil, err
 	}
 
+	names := NameSet(crt)
 	crtBuf := new(bytes.Buffer)
-	if err = util.EncodeX509Cert(crtBuf, crtBytes); err != nil {
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		reused, err := checkKeyReuse(tx, fingerprint, profile)
+		if err != nil {
+			return err
+		}
+
+		duplicates, err := a.checkUniqueness(tx, names, params)
+		if err != nil {
+			return err
+		}
+
+		crt.SerialNumber, err = a.nextSerial(tx)
+		if err != nil {
+			return err
+		}
+
+		crtBytes, err := x509.CreateCertificate(rand.Reader, crt, a.Cert, csr.PublicKey, a.Signer)
+		if err != nil {
+			return err
+		}
+
+		if err = util.EncodeX509Cert(crtBuf, crtBytes); err != nil {
+			return err
+		}
+
+		for _, issuer := range a.IssuerChain() {
+			if err = util.EncodeX509Cert(crtBuf, issuer.Raw); err != nil {
+				return err
+			}
+		}
+
+		sigCert := db.SignedCertificate{
+			Serial:    SerialString(crt.SerialNumber),
+			NotBefore: crt.NotBefore,
+			NotAfter:  crt.NotAfter,
+
+			Issuer:  a.Cert.Subject,
+			Subject: crt.Subject,
+
+			AuthorityKeyID: KeyID(a.Cert),
+
+			SANs:    SubjectAltNames(crt),
+			NameSet: names,
+
+			ClientAuth: params.ClientAuth,
+			ServerAuth: params.ServerAuth,
+			IsCA:       crt.IsCA,
+
+			SPKIFingerprint: fingerprint,
+		}
+
+		if err = tx.Create(&sigCert).Error; err != nil {
+			return err
+		}
+
+		revoked := map[int64]bool{}
+		for _, prev := range append(reused, duplicates...) {
+			if revoked[prev.ID] {
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"serial":     prev.Serial,
+				"replacedBy": sigCert.Serial,
+			}).Info("revoking superseded certificate")
+
+			if err = prev.Revoke(tx, db.ReasonSuperseded); err != nil {
+				return err
+			}
+
+			revoked[prev.ID] = true
+		}
+
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	sigCert := db.SignedCertificate{
-		ID:        serial,
-		NotBefore: crt.NotBefore,
-		NotAfter:  crt.NotAfter,
+	return crtBuf.Bytes(), nil
+}
 
-		Issuer:  CA.Subject,
-		Subject: crt.Subject,
+// SubjectAltNames returns the subject alternative names of crt as strings
+func SubjectAltNames(crt *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, crt.DNSNames...)
+	for _, ip := range crt.IPAddresses {
+		sans = append(sans, ip.String())
 	}
 
-	if err = db.DB.Create(&sigCert).Error; err != nil {
-		return nil, err
+	sans = append(sans, crt.EmailAddresses...)
+	for _, uri := range crt.URIs {
+		sans = append(sans, uri.String())
 	}
 
-	return crtBuf.Bytes(), nil
+	return sans
 }
@@ -0,0 +1,170 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+// testAuthority returns a self-signed certificate authority backed by a
+// database connection of its own on path
+func testAuthority(t *testing.T, conf *viper.Viper, path string, key ed25519.PrivateKey, crt *x509.Certificate) *Authority {
+	conn, err := db.Open(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Authority{Cert: crt, Signer: key, DB: conn, conf: conf}
+}
+
+// testCACert returns a self-signed certificate authority certificate & key
+func testCACert(t *testing.T) (ed25519.PrivateKey, *x509.Certificate) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, crt
+}
+
+func testCSR(t *testing.T, cn string) *x509.CertificateRequest {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: []string{cn},
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return csr
+}
+
+// TestConcurrentIssuance issues certificates in parallel through two database
+// handles, like two zcert processes sharing a database, and checks every
+// serial is handed out once and every certificate is recorded
+func TestConcurrentIssuance(t *testing.T) {
+	const total, workers = 2000, 16
+
+	key, crt := testCACert(t)
+
+	conf := viper.New()
+	path := filepath.Join(t.TempDir(), "zcert.sqlite3")
+	authorities := []*Authority{
+		testAuthority(t, conf, path, key, crt),
+		testAuthority(t, conf, path, key, crt),
+	}
+
+	// the CSRs are made up front so the workers spend their time issuing
+	csrs := make([]*x509.CertificateRequest, total)
+	for i := range csrs {
+		csrs[i] = testCSR(t, fmt.Sprintf("host%d.example.com", i))
+	}
+
+	serials := make([]*big.Int, total)
+	errs := make(chan error, total)
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(a *Authority) {
+			defer wg.Done()
+			for i := range jobs {
+				signed, err := a.SignCSR(csrs[i], CSRParams{Lifetime: time.Hour, ServerAuth: true})
+				if err != nil {
+					errs <- err
+					continue
+				}
+
+				leaf, err := util.DecodeX509Cert(bytes.NewReader(signed))
+				if err != nil {
+					errs <- err
+					continue
+				}
+
+				serials[i] = leaf.SerialNumber
+			}
+		}(authorities[w%len(authorities)])
+	}
+
+	for i := 0; i < total; i++ {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("issuing failed: %s", err)
+	}
+
+	seen := map[string]bool{}
+	for i, serial := range serials {
+		s := SerialString(serial)
+		if seen[s] {
+			t.Fatalf("serial %s was issued twice, the second time for certificate %d", s, i)
+		}
+
+		seen[s] = true
+	}
+
+	recorded, err := db.AllCertificates(authorities[0].DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorded) != total {
+		t.Fatalf("%d certificates were recorded, expected %d", len(recorded), total)
+	}
+
+	for _, record := range recorded {
+		if !seen[record.Serial] {
+			t.Fatalf("recorded serial %s wasn't issued", record.Serial)
+		}
+	}
+}
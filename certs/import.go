@@ -0,0 +1,116 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/util"
+)
+
+type ImportError struct {
+	reason string
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("can't import certificate authority: %s", e.reason)
+}
+
+func (a *Authority) importPassphrase() ([]byte, error) {
+	return a.readPassphrase(a.conf.GetInt("ca.passphrase_fd"), true, "imported key passphrase: ", false)
+}
+
+// Import installs an existing certificate authority, e.g. one managed with
+// OpenSSL, as ca.crt & ca.key. Certificates after the first in crtPath are
+// the ones above it and are written to chain.crt. The pair is checked before
+// anything is written.
+func (a *Authority) Import(crtPath, keyPath string, force bool) (*x509.Certificate, error) {
+	if signer := a.conf.GetString("ca.signer"); signer != SignerFile {
+		return nil, &ImportError{reason: fmt.Sprintf("keys can only be imported with ca.signer %s, not %s", SignerFile, signer)}
+	}
+
+	crts, err := util.DecodeX509CertsFromPath(crtPath)
+	if err != nil {
+		return nil, err
+	}
+
+	crt := crts[0]
+	if !crt.IsCA || !crt.BasicConstraintsValid {
+		return nil, &NotCAError{path: crtPath}
+	}
+
+	if crt.KeyUsage != 0 && crt.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, &ImportError{reason: "certificate may not sign certificates"}
+	}
+
+	if now := time.Now(); now.Before(crt.NotBefore) || now.After(crt.NotAfter) {
+		return nil, &ImportError{reason: fmt.Sprintf("certificate is only valid from %s to %s", crt.NotBefore, crt.NotAfter)}
+	}
+
+	if !canSignCRLs(crt) {
+		log.Warn("imported certificate authority may not sign CRLs, /crl will not work")
+	}
+
+	key, err := util.DecodePrivateKey(keyPath, &util.KeySecrets{Passphrase: a.importPassphrase})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkKey(keyPath, key, crt); err != nil {
+		return nil, err
+	}
+
+	if len(crts) > 1 {
+		if err = crt.CheckSignatureFrom(crts[1]); err != nil {
+			return nil, &ImportError{reason: fmt.Sprintf("second certificate in %s did not sign the first: %s", crtPath, err)}
+		}
+	}
+
+	if err = os.MkdirAll(a.conf.GetString("storage.path"), 0700); err != nil {
+		return nil, err
+	}
+
+	// chain.crt is always checked, as one left behind by a previous
+	// certificate authority would be served as the new one's chain
+	for _, f := range []string{"ca.crt", "ca.key", "chain.crt"} {
+		if err = checkFile(a.storagePath(f), force); err != nil {
+			return nil, err
+		}
+	}
+
+	keyBuf := new(bytes.Buffer)
+	if err = a.encodeKey(keyBuf, key); err != nil {
+		return nil, err
+	}
+
+	if err = writeFile(a.storagePath("ca.key"), keyBuf, 0600); err != nil {
+		return nil, err
+	}
+
+	if err = writeCert(a.storagePath("ca.crt"), crt); err != nil {
+		return nil, err
+	}
+
+	if len(crts) > 1 {
+		chainBuf := new(bytes.Buffer)
+		for _, c := range crts[1:] {
+			if err = util.EncodeX509Cert(chainBuf, c.Raw); err != nil {
+				return nil, err
+			}
+		}
+
+		if err = writeFile(a.storagePath("chain.crt"), chainBuf, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"subject": crt.Subject.String(),
+		"keyID":   KeyID(crt),
+	}).Info("imported certificate authority")
+
+	return crt, nil
+}
@@ -0,0 +1,108 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/zcert/util"
+)
+
+// writeImport writes key and crts to files to import from
+func writeImport(t *testing.T, dir string, key ed25519.PrivateKey, crts ...*x509.Certificate) (string, string) {
+	crtBuf := new(bytes.Buffer)
+	for _, crt := range crts {
+		if err := util.EncodeX509Cert(crtBuf, crt.Raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keyBuf := new(bytes.Buffer)
+	if err := util.EncodePrivateKey(keyBuf, key); err != nil {
+		t.Fatal(err)
+	}
+
+	crtPath, keyPath := filepath.Join(dir, "import.crt"), filepath.Join(dir, "import.key")
+	if err := os.WriteFile(crtPath, crtBuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyPath, keyBuf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return crtPath, keyPath
+}
+
+// TestImportReplacesChain imports an intermediate with its root, then forces
+// the import of a self-signed root over it, which must not keep the
+// intermediate's chain
+func TestImportReplacesChain(t *testing.T) {
+	rootKey, root := testCACert(t)
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "test intermediate"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, root, pub, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intermediate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := t.TempDir()
+	conf := viper.New()
+	conf.Set("storage.path", storage)
+	conf.Set("ca.signer", SignerFile)
+	a := &Authority{conf: conf}
+
+	crtPath, keyPath := writeImport(t, t.TempDir(), key, intermediate, root)
+	if _, err = a.Import(crtPath, keyPath, false); err != nil {
+		t.Fatal(err)
+	}
+
+	chainPath := filepath.Join(storage, "chain.crt")
+	if _, err = os.Stat(chainPath); err != nil {
+		t.Fatalf("importing an intermediate didn't write chain.crt: %s", err)
+	}
+
+	otherKey, other := testCACert(t)
+	crtPath, keyPath = writeImport(t, t.TempDir(), otherKey, other)
+
+	var exists *FileExistsError
+	if _, err = a.Import(crtPath, keyPath, false); !errors.As(err, &exists) {
+		t.Fatalf("importing over an existing certificate authority without force returned %v", err)
+	}
+
+	if _, err = a.Import(crtPath, keyPath, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(chainPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("chain.crt of the previous certificate authority was kept: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// kinds of key a certificate authority can be created with, set by ca.keytype
+const (
+	KeyTypeEd25519   = "ed25519"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeRSA3072   = "rsa-3072"
+)
+
+type UnknownKeyTypeError struct {
+	keyType string
+}
+
+func (e *UnknownKeyTypeError) Error() string {
+	return fmt.Sprintf("unknown ca.keytype %q, use ed25519, ecdsa-p256, ecdsa-p384 or rsa-3072", e.keyType)
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, &UnknownKeyTypeError{keyType: keyType}
+	}
+}
+
+// leafKeyUsage is the key usage of a certificate for pub. Only rsa keys can
+// encipher the keys of a TLS key exchange.
+func leafKeyUsage(pub crypto.PublicKey) x509.KeyUsage {
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+
+	return x509.KeyUsageDigitalSignature
+}
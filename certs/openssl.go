@@ -0,0 +1,318 @@
+package certs
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+	"gorm.io/gorm"
+)
+
+// OpenSSL's names for revocation reasons. keyTime & CAkeyTime are key
+// compromises with a compromise time, holdInstruction a hold with an OID.
+var opensslReasons = map[string]string{
+	"unspecified":          db.ReasonUnspecified,
+	"keyCompromise":        db.ReasonKeyCompromise,
+	"keyTime":              db.ReasonKeyCompromise,
+	"CACompromise":         db.ReasonCACompromise,
+	"CAkeyTime":            db.ReasonCACompromise,
+	"affiliationChanged":   db.ReasonAffiliationChanged,
+	"superseded":           db.ReasonSuperseded,
+	"cessationOfOperation": db.ReasonCessationOfOperation,
+	"certificateHold":      db.ReasonCertificateHold,
+	"holdInstruction":      db.ReasonCertificateHold,
+}
+
+type IndexLineError struct {
+	line   int
+	reason string
+}
+
+func (e *IndexLineError) Error() string {
+	return fmt.Sprintf("index line %d: %s", e.line, e.reason)
+}
+
+// OpenSSLImport counts what ImportOpenSSL did
+type OpenSSLImport struct {
+	Imported   int
+	Revoked    int
+	Existing   int
+	WithoutPEM int
+	Mismatched int
+}
+
+// opensslEntry is a line of an OpenSSL ca index.txt
+type opensslEntry struct {
+	status    string
+	expires   time.Time
+	revokedAt time.Time
+	reason    string
+	serial    *big.Int
+	subject   string
+}
+
+func parseOpenSSLTime(s string) (time.Time, error) {
+	if len(s) == len("060102150405Z") {
+		return time.Parse("060102150405Z", s)
+	}
+
+	return time.Parse("20060102150405Z", s)
+}
+
+func parseIndexLine(n int, line string) (*opensslEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return nil, &IndexLineError{line: n, reason: fmt.Sprintf("expected 6 tab separated fields, found %d", len(fields))}
+	}
+
+	entry := &opensslEntry{status: fields[0], subject: fields[5]}
+
+	var err error
+	entry.expires, err = parseOpenSSLTime(fields[1])
+	if err != nil {
+		return nil, &IndexLineError{line: n, reason: err.Error()}
+	}
+
+	var ok bool
+	entry.serial, ok = new(big.Int).SetString(fields[3], 16)
+	if !ok {
+		return nil, &IndexLineError{line: n, reason: fmt.Sprintf("invalid serial %q", fields[3])}
+	}
+
+	switch entry.status {
+	case "V", "E":
+	case "R":
+		revocation := strings.Split(fields[2], ",")
+		entry.revokedAt, err = parseOpenSSLTime(revocation[0])
+		if err != nil {
+			return nil, &IndexLineError{line: n, reason: err.Error()}
+		}
+
+		entry.reason = db.ReasonUnspecified
+		if len(revocation) > 1 {
+			if revocation[1] == "removeFromCRL" {
+				// taken off hold, so no longer revoked
+				entry.status = "V"
+				break
+			}
+
+			reason, ok := opensslReasons[revocation[1]]
+			if !ok {
+				return nil, &IndexLineError{line: n, reason: fmt.Sprintf("unknown revocation reason %q", revocation[1])}
+			}
+
+			entry.reason = reason
+		}
+	default:
+		return nil, &IndexLineError{line: n, reason: fmt.Sprintf("unknown status %q", entry.status)}
+	}
+
+	return entry, nil
+}
+
+// readIssuedCert reads the certificate OpenSSL stored for serial in certsDir,
+// named by the upper case hex serial padded to whole bytes
+func readIssuedCert(certsDir string, serial *big.Int) (*x509.Certificate, error) {
+	name := strings.ToUpper(serial.Text(16))
+	if len(name)%2 == 1 {
+		name = "0" + name
+	}
+
+	crt, err := util.DecodeX509CertFromPath(filepath.Join(certsDir, name+".pem"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return crt, err
+}
+
+// checkIssuedCert makes sure the certificate stored for entry is the one the
+// index describes: the same serial, signed by issuer
+func checkIssuedCert(entry *opensslEntry, crt, issuer *x509.Certificate) string {
+	if crt.SerialNumber.Cmp(entry.serial) != 0 {
+		return fmt.Sprintf("certificate has serial %s", SerialString(crt.SerialNumber))
+	}
+
+	if err := crt.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Sprintf("certificate wasn't signed by %s: %s", issuer.Subject, err)
+	}
+
+	return ""
+}
+
+// opensslCommonName picks the common name out of a subject in OpenSSL's
+// /C=../O=../CN=.. form
+func opensslCommonName(subject string) string {
+	for _, rdn := range strings.Split(subject, "/") {
+		if strings.HasPrefix(rdn, "CN=") {
+			return strings.TrimPrefix(rdn, "CN=")
+		}
+	}
+
+	return ""
+}
+
+// opensslRecord builds the inventory record of an imported certificate. When
+// the certificate itself is missing only what's in the index is recorded, and
+// it is attributed to issuer.
+func opensslRecord(entry *opensslEntry, crt *x509.Certificate, issuer *x509.Certificate) (*db.SignedCertificate, error) {
+	record := &db.SignedCertificate{
+		Serial:   SerialString(entry.serial),
+		NotAfter: entry.expires,
+		Issuer:   issuer.Subject,
+
+		AuthorityKeyID: KeyID(issuer),
+
+		Revoked:          entry.status == "R",
+		RevokedAt:        entry.revokedAt,
+		RevocationReason: entry.reason,
+	}
+
+	if crt == nil {
+		record.Subject.CommonName = opensslCommonName(entry.subject)
+		return record, nil
+	}
+
+	fingerprint, err := SPKIFingerprint(crt.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	record.NotBefore = crt.NotBefore
+	record.NotAfter = crt.NotAfter
+	record.Issuer = crt.Issuer
+	record.Subject = crt.Subject
+	record.SANs = SubjectAltNames(crt)
+	record.NameSet = NameSet(crt)
+	record.IsCA = crt.IsCA
+	record.SPKIFingerprint = fingerprint
+
+	if len(crt.AuthorityKeyId) > 0 {
+		record.AuthorityKeyID = hex.EncodeToString(crt.AuthorityKeyId)
+	}
+
+	for _, usage := range crt.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageClientAuth:
+			record.ClientAuth = true
+		case x509.ExtKeyUsageServerAuth:
+			record.ServerAuth = true
+		}
+	}
+
+	return record, nil
+}
+
+// ImportOpenSSL records the certificates in an OpenSSL ca database, so that
+// zcert's CRLs, key reuse and uniqueness checks cover them too. indexPath is
+// OpenSSL's index.txt and certsDir its new_certs_dir. Certificates that are
+// already recorded are skipped, so an import can be repeated. Entries whose
+// certificate is missing from certsDir are attributed to issuer, and ones
+// whose certificate has another serial or wasn't signed by issuer are
+// skipped. Sequential
+// serials continue above the highest serial in the index.
+func (a *Authority) ImportOpenSSL(indexPath, certsDir string, issuer *x509.Certificate) (*OpenSSLImport, error) {
+	index, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+
+	var entries []*opensslEntry
+	highest := new(big.Int)
+	scanner := bufio.NewScanner(index)
+	for n := 1; scanner.Scan(); n++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		entry, err := parseIndexLine(n, scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+		if entry.serial.Cmp(highest) > 0 {
+			highest = entry.serial
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := &OpenSSLImport{}
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		for _, entry := range entries {
+			exists, err := db.SerialExists(tx, SerialString(entry.serial))
+			if err != nil {
+				return err
+			}
+
+			if exists {
+				stats.Existing++
+				continue
+			}
+
+			crt, err := readIssuedCert(certsDir, entry.serial)
+			if err != nil {
+				return err
+			}
+
+			if crt == nil {
+				log.WithFields(log.Fields{
+					"serial":  SerialString(entry.serial),
+					"subject": entry.subject,
+				}).Warn("certificate missing from certs directory, importing the index entry only")
+
+				stats.WithoutPEM++
+			} else if reason := checkIssuedCert(entry, crt, issuer); len(reason) > 0 {
+				log.WithFields(log.Fields{
+					"serial":  SerialString(entry.serial),
+					"subject": entry.subject,
+					"reason":  reason,
+				}).Warn("certificate in certs directory doesn't match the index entry, skipping it")
+
+				stats.Mismatched++
+				continue
+			}
+
+			record, err := opensslRecord(entry, crt, issuer)
+			if err != nil {
+				return err
+			}
+
+			if err = tx.Create(record).Error; err != nil {
+				return err
+			}
+
+			stats.Imported++
+			if record.Revoked {
+				stats.Revoked++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) > 0 {
+		if err = a.SeedSerial(highest); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,94 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+// writeIssued signs a leaf with serial under issuer and stores it in dir the
+// way OpenSSL does, as <name>.pem
+func writeIssued(t *testing.T, dir, name string, serial int64, issuer *x509.Certificate, issuerKey ed25519.PrivateKey) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("host%d.example.com", serial)},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, pub, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err = util.EncodeX509Cert(buf, der); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, name+".pem"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportOpenSSLChecksCertificates(t *testing.T) {
+	key, crt := testCACert(t)
+	otherKey, other := testCACert(t)
+
+	certsDir := t.TempDir()
+	writeIssued(t, certsDir, "01", 1, crt, key)
+	// signed by another certificate authority
+	writeIssued(t, certsDir, "02", 2, other, otherKey)
+	// stored under the wrong serial
+	writeIssued(t, certsDir, "03", 4, crt, key)
+
+	expires := time.Now().Add(time.Hour).UTC().Format("060102150405Z")
+	var index strings.Builder
+	for _, serial := range []string{"01", "02", "03", "05"} {
+		fmt.Fprintf(&index, "V\t%s\t\t%s\tunknown\t/CN=host%s.example.com\n", expires, serial, serial)
+	}
+
+	indexPath := filepath.Join(t.TempDir(), "index.txt")
+	if err := os.WriteFile(indexPath, []byte(index.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := testAuthority(t, viper.New(), filepath.Join(t.TempDir(), "zcert.sqlite3"), key, crt)
+	stats, err := a.ImportOpenSSL(indexPath, certsDir, crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Imported != 2 || stats.Mismatched != 2 || stats.WithoutPEM != 1 {
+		t.Fatalf("imported %d, mismatched %d and without pem %d, expected 2, 2 and 1", stats.Imported, stats.Mismatched, stats.WithoutPEM)
+	}
+
+	for serial, want := range map[int64]bool{1: true, 2: false, 3: false, 5: true} {
+		exists, err := db.SerialExists(a.DB, SerialString(big.NewInt(serial)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exists != want {
+			t.Errorf("serial %d recorded %t, expected %t", serial, exists, want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/util"
+	"golang.org/x/term"
+)
+
+type NoPassphraseError struct{}
+
+type EmptyPassphraseError struct{}
+
+type PassphraseMismatchError struct{}
+
+type NoKeyFilesError struct {
+	signer string
+}
+
+func (e *NoPassphraseError) Error() string {
+	return "no passphrase available: use --passphrase-fd, set the variable named by ca.passphrase_env, or run from a terminal"
+}
+
+func (e *EmptyPassphraseError) Error() string {
+	return "passphrase is empty"
+}
+
+func (e *PassphraseMismatchError) Error() string {
+	return "passphrases do not match"
+}
+
+func (e *NoKeyFilesError) Error() string {
+	return fmt.Sprintf("ca.signer %s does not keep keys in files, change the passphrase with its own tools", e.signer)
+}
+
+// readLine reads up to the first newline from f one byte at a time, so that
+// several passphrases can be read from the same file descriptor
+func readLine(f *os.File) ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := f.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+
+			line = append(line, b[0])
+		}
+
+		if err != nil {
+			if len(line) > 0 {
+				break
+			}
+
+			return nil, err
+		}
+	}
+
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
+
+func promptPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	return term.ReadPassword(int(os.Stdin.Fd()))
+}
+
+// readPassphrase reads a passphrase from fd when it isn't negative, then from
+// the environment variable named by ca.passphrase_env when useEnv is set, and
+// finally from the terminal, asking twice when confirm is set
+func (a *Authority) readPassphrase(fd int, useEnv bool, prompt string, confirm bool) ([]byte, error) {
+	var passphrase []byte
+	var err error
+
+	if len(a.Name) > 0 {
+		prompt = a.Name + " " + prompt
+	}
+
+	if fd >= 0 {
+		passphrase, err = readLine(os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd)))
+		if err != nil {
+			return nil, err
+		}
+	} else if env := os.Getenv(a.conf.GetString("ca.passphrase_env")); useEnv && len(env) > 0 {
+		passphrase = []byte(env)
+	} else if term.IsTerminal(int(os.Stdin.Fd())) {
+		passphrase, err = promptPassphrase(prompt)
+		if err != nil {
+			return nil, err
+		}
+
+		if confirm {
+			again, err := promptPassphrase("repeat " + prompt)
+			if err != nil {
+				return nil, err
+			}
+
+			if !bytes.Equal(passphrase, again) {
+				return nil, &PassphraseMismatchError{}
+			}
+		}
+	} else {
+		return nil, &NoPassphraseError{}
+	}
+
+	if len(passphrase) == 0 {
+		return nil, &EmptyPassphraseError{}
+	}
+
+	return passphrase, nil
+}
+
+// Passphrase returns the passphrase protecting the CA keys, reading it the
+// first time it is needed
+func (a *Authority) Passphrase() ([]byte, error) {
+	if a.passphrase != nil {
+		return a.passphrase, nil
+	}
+
+	passphrase, err := a.readPassphrase(a.conf.GetInt("ca.passphrase_fd"), true, "CA key passphrase: ", false)
+	if err != nil {
+		return nil, err
+	}
+
+	a.passphrase = passphrase
+	return a.passphrase, nil
+}
+
+// EncryptKeys reads a new passphrase that every CA key created afterwards is
+// encrypted with
+func (a *Authority) EncryptKeys() error {
+	passphrase, err := a.readPassphrase(a.conf.GetInt("ca.passphrase_fd"), true, "new CA key passphrase: ", true)
+	if err != nil {
+		return err
+	}
+
+	a.passphrase = passphrase
+	return nil
+}
+
+func (a *Authority) rootPassphrase() ([]byte, error) {
+	return a.readPassphrase(a.conf.GetInt("ca.passphrase_fd"), true, "root key passphrase: ", false)
+}
+
+func (a *Authority) decodeKey(path string) (crypto.Signer, error) {
+	return util.DecodePrivateKey(path, &util.KeySecrets{
+		Passphrase: a.Passphrase,
+		SplitKey:   a.awaitShares,
+	})
+}
+
+// encodeKey encodes a CA key the same way as the current ones: sealed under
+// the split key, encrypted with the passphrase, or in the clear
+func (a *Authority) encodeKey(buf *bytes.Buffer, key crypto.Signer) error {
+	if a.splitKey != nil {
+		return util.EncodeSplitPrivateKey(buf, key, a.splitKey, a.splitThreshold)
+	}
+
+	if a.passphrase != nil {
+		return util.EncodeEncryptedPrivateKey(buf, key, a.passphrase)
+	}
+
+	return util.EncodePrivateKey(buf, key)
+}
+
+// KeyPaths returns ca.key, the keys of every retired certificate authority
+// and the SSH certificate authority's key
+func (a *Authority) KeyPaths() ([]string, error) {
+	if signer := a.conf.GetString("ca.signer"); signer != SignerFile {
+		return nil, &NoKeyFilesError{signer: signer}
+	}
+
+	retired, err := filepath.Glob(a.retiredPath("*", "ca.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	paths := append([]string{a.storagePath("ca.key")}, retired...)
+	if _, err = os.Stat(a.storagePath(sshKeyName)); err == nil {
+		paths = append(paths, a.storagePath(sshKeyName))
+	}
+
+	return paths, nil
+}
+
+func (a *Authority) decodeKeys(paths []string) ([]crypto.Signer, error) {
+	keys := make([]crypto.Signer, len(paths))
+	for i, path := range paths {
+		key, err := a.decodeKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// rewriteKeys writes keys back to paths with encodeKey
+func (a *Authority) rewriteKeys(paths []string, keys []crypto.Signer) error {
+	for i, path := range paths {
+		buf := new(bytes.Buffer)
+		if err := a.encodeKey(buf, keys[i]); err != nil {
+			return err
+		}
+
+		// write next to the key and rename over it, so the key is never
+		// left half written
+		tmp := path + ".new"
+		if err := checkFile(tmp, true); err != nil {
+			return err
+		}
+
+		if err := writeFile(tmp, buf, 0600); err != nil {
+			return err
+		}
+
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Info("rewrote key")
+	}
+
+	return nil
+}
+
+// ChangePassphrase re-encrypts the keys in paths with a new passphrase read
+// from newFD, or from the terminal when newFD is negative. The keys are
+// stored unencrypted when decrypt is set. Every key is decrypted before any
+// is rewritten, so a wrong passphrase leaves all of them untouched.
+func (a *Authority) ChangePassphrase(paths []string, newFD int, decrypt bool) error {
+	keys, err := a.decodeKeys(paths)
+	if err != nil {
+		return err
+	}
+
+	a.passphrase = nil
+	a.splitKey = nil
+	if !decrypt {
+		a.passphrase, err = a.readPassphrase(newFD, false, "new CA key passphrase: ", true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.rewriteKeys(paths, keys)
+}
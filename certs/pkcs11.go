@@ -0,0 +1,513 @@
+//go:build cgo
+
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// EdDSA was added in PKCS#11 v3.0, after the constants in the pkcs11 package
+// were generated
+const (
+	ckkECEdwards           = 0x00000040
+	ckmECEdwardsKeyPairGen = 0x00001055
+	ckmEdDSA               = 0x00001057
+)
+
+// DER encoded OIDs of the curves, used as CKA_EC_PARAMS
+var (
+	ed25519Params = []byte{0x06, 0x03, 0x2b, 0x65, 0x70}
+	p256Params    = []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+	p384Params    = []byte{0x06, 0x05, 0x2b, 0x81, 0x04, 0x00, 0x22}
+)
+
+// DER encoded DigestInfo prefixes that CKM_RSA_PKCS expects before a digest
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+type PKCS11ModuleError struct {
+	module string
+}
+
+type TokenNotFoundError struct {
+	label string
+}
+
+type KeyNotFoundError struct {
+	label string
+}
+
+type KeyExistsError struct {
+	label string
+}
+
+type UnsupportedKeyError struct {
+	label string
+}
+
+type NoPINError struct{}
+
+type HashedMessageError struct{}
+
+type UnsupportedSignatureError struct {
+	reason string
+}
+
+func (e *PKCS11ModuleError) Error() string {
+	return fmt.Sprintf("unable to load PKCS#11 module %q", e.module)
+}
+
+func (e *TokenNotFoundError) Error() string {
+	return fmt.Sprintf("no PKCS#11 token labelled %q", e.label)
+}
+
+func (e *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("no key labelled %q in the PKCS#11 token", e.label)
+}
+
+func (e *KeyExistsError) Error() string {
+	return fmt.Sprintf("a key labelled %q already exists in the PKCS#11 token, delete it first", e.label)
+}
+
+func (e *UnsupportedKeyError) Error() string {
+	return fmt.Sprintf("key labelled %q in the PKCS#11 token is not an ed25519, ecdsa or rsa key", e.label)
+}
+
+func (e *HashedMessageError) Error() string {
+	return "ed25519 keys can only sign unhashed messages"
+}
+
+func (e *UnsupportedSignatureError) Error() string {
+	return fmt.Sprintf("unsupported PKCS#11 signature: %s", e.reason)
+}
+
+func (e *NoPINError) Error() string {
+	return "no PKCS#11 PIN: set ca.pkcs11.pin, the variable named by ca.pkcs11.pin_env, or run from a terminal"
+}
+
+// pkcs11Backend keeps the keys in a PKCS#11 token, labelled with
+// ca.pkcs11.label_prefix followed by the key's name. A single logged in
+// session is shared by every key, so operations on it are serialized.
+type pkcs11Backend struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	conf    *viper.Viper
+}
+
+type pkcs11Signer struct {
+	backend *pkcs11Backend
+	key     pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+}
+
+func pkcs11PIN(conf *viper.Viper) (string, error) {
+	if pin := conf.GetString("ca.pkcs11.pin"); len(pin) > 0 {
+		return pin, nil
+	}
+
+	if pin := os.Getenv(conf.GetString("ca.pkcs11.pin_env")); len(pin) > 0 {
+		return pin, nil
+	}
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		pin, err := promptPassphrase("PKCS#11 PIN: ")
+		return string(pin), err
+	}
+
+	return "", &NoPINError{}
+}
+
+func findSlot(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, err
+		}
+
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+
+	return 0, &TokenNotFoundError{label: label}
+}
+
+func newPKCS11Backend(conf *viper.Viper) (SignerBackend, error) {
+	module := conf.GetString("ca.pkcs11.module")
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, &PKCS11ModuleError{module: module}
+	}
+
+	// every certificate authority using the module opens it
+	err := ctx.Initialize()
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return nil, err
+	}
+
+	slot, err := findSlot(ctx, conf.GetString("ca.pkcs11.token_label"))
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return nil, err
+	}
+
+	pin, err := pkcs11PIN(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.Login(session, pkcs11.CKU_USER, pin)
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return nil, err
+	}
+
+	return &pkcs11Backend{ctx: ctx, session: session, conf: conf}, nil
+}
+
+func (pb *pkcs11Backend) label(name string) string {
+	return pb.conf.GetString("ca.pkcs11.label_prefix") + name
+}
+
+// findObjects returns every object of class with the given label. The caller
+// must hold pb.mu.
+func (pb *pkcs11Backend) findObjects(class uint, label string) ([]pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	if err := pb.ctx.FindObjectsInit(pb.session, template); err != nil {
+		return nil, err
+	}
+
+	objects, _, err := pb.ctx.FindObjects(pb.session, 2)
+	if err != nil {
+		pb.ctx.FindObjectsFinal(pb.session)
+		return nil, err
+	}
+
+	return objects, pb.ctx.FindObjectsFinal(pb.session)
+}
+
+// findKeyPair returns the private & public key objects with the given label.
+// The caller must hold pb.mu.
+func (pb *pkcs11Backend) findKeyPair(label string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	priv, err := pb.findObjects(pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pub, err := pb.findObjects(pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(priv) != 1 || len(pub) != 1 {
+		return 0, 0, &KeyNotFoundError{label: label}
+	}
+
+	return priv[0], pub[0], nil
+}
+
+// attributes reads the given attributes of an object. The caller must hold
+// pb.mu.
+func (pb *pkcs11Backend) attributes(object pkcs11.ObjectHandle, types ...uint) (map[uint][]byte, error) {
+	template := make([]*pkcs11.Attribute, len(types))
+	for i, t := range types {
+		template[i] = pkcs11.NewAttribute(t, nil)
+	}
+
+	attrs, err := pb.ctx.GetAttributeValue(pb.session, object, template)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[uint][]byte, len(attrs))
+	for _, attr := range attrs {
+		values[attr.Type] = attr.Value
+	}
+
+	return values, nil
+}
+
+// ecPoint unwraps a CKA_EC_POINT. It should be a DER encoded octet string,
+// but some tokens return the point raw.
+func ecPoint(point []byte) []byte {
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		return raw
+	}
+
+	return point
+}
+
+// publicKey reads the public key of a key pair. The caller must hold pb.mu.
+func (pb *pkcs11Backend) publicKey(label string, pub pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := pb.attributes(pub, pkcs11.CKA_KEY_TYPE)
+	if err != nil {
+		return nil, err
+	}
+
+	keyType := attrs[pkcs11.CKA_KEY_TYPE]
+	if len(keyType) == 0 {
+		return nil, &UnsupportedKeyError{label: label}
+	}
+
+	switch keyType[0] {
+	case ckkECEdwards:
+		attrs, err = pb.attributes(pub, pkcs11.CKA_EC_POINT)
+		if err != nil {
+			return nil, err
+		}
+
+		point := ecPoint(attrs[pkcs11.CKA_EC_POINT])
+		if len(point) != ed25519.PublicKeySize {
+			return nil, &UnsupportedKeyError{label: label}
+		}
+
+		return ed25519.PublicKey(point), nil
+	case pkcs11.CKK_EC:
+		attrs, err = pb.attributes(pub, pkcs11.CKA_EC_PARAMS, pkcs11.CKA_EC_POINT)
+		if err != nil {
+			return nil, err
+		}
+
+		var curve elliptic.Curve
+		switch string(attrs[pkcs11.CKA_EC_PARAMS]) {
+		case string(p256Params):
+			curve = elliptic.P256()
+		case string(p384Params):
+			curve = elliptic.P384()
+		default:
+			return nil, &UnsupportedKeyError{label: label}
+		}
+
+		x, y := elliptic.Unmarshal(curve, ecPoint(attrs[pkcs11.CKA_EC_POINT]))
+		if x == nil {
+			return nil, &UnsupportedKeyError{label: label}
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case pkcs11.CKK_RSA:
+		attrs, err = pb.attributes(pub, pkcs11.CKA_MODULUS, pkcs11.CKA_PUBLIC_EXPONENT)
+		if err != nil {
+			return nil, err
+		}
+
+		e := new(big.Int).SetBytes(attrs[pkcs11.CKA_PUBLIC_EXPONENT])
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, &UnsupportedKeyError{label: label}
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[pkcs11.CKA_MODULUS]),
+			E: int(e.Int64()),
+		}, nil
+	default:
+		return nil, &UnsupportedKeyError{label: label}
+	}
+}
+
+// signer reads the public key of a key pair. The caller must hold pb.mu.
+func (pb *pkcs11Backend) signer(label string, priv, pub pkcs11.ObjectHandle) (crypto.Signer, error) {
+	key, err := pb.publicKey(label, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkcs11Signer{
+		backend: pb,
+		key:     priv,
+		pub:     key,
+	}, nil
+}
+
+// keyPairTemplate returns the mechanism and public key attributes that
+// generate a key of keyType
+func keyPairTemplate(keyType string) (*pkcs11.Mechanism, []*pkcs11.Attribute, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		return pkcs11.NewMechanism(ckmECEdwardsKeyPairGen, nil), []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ed25519Params),
+		}, nil
+	case KeyTypeECDSAP256:
+		return pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil), []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, p256Params),
+		}, nil
+	case KeyTypeECDSAP384:
+		return pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil), []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, p384Params),
+		}, nil
+	case KeyTypeRSA3072:
+		return pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil), []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 3072),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{0x01, 0x00, 0x01}),
+		}, nil
+	default:
+		return nil, nil, &UnknownKeyTypeError{keyType: keyType}
+	}
+}
+
+func (pb *pkcs11Backend) LoadKey(name string) (crypto.Signer, error) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	label := pb.label(name)
+	priv, pub, err := pb.findKeyPair(label)
+	if err != nil {
+		return nil, err
+	}
+
+	return pb.signer(label, priv, pub)
+}
+
+func (pb *pkcs11Backend) GenerateKey(name, keyType string) (crypto.Signer, error) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	label := pb.label(name)
+	existing, err := pb.findObjects(pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(existing) > 0 {
+		return nil, &KeyExistsError{label: label}
+	}
+
+	mech, params, err := keyPairTemplate(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	pubTemplate := append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}, params...)
+
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	pub, priv, err := pb.ctx.GenerateKeyPair(pb.session, []*pkcs11.Mechanism{mech}, pubTemplate, privTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return pb.signer(label, priv, pub)
+}
+
+func (pb *pkcs11Backend) RenameKey(from, to string) error {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	priv, pub, err := pb.findKeyPair(pb.label(from))
+	if err != nil {
+		return err
+	}
+
+	label := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, pb.label(to))}
+	if err = pb.ctx.SetAttributeValue(pb.session, priv, label); err != nil {
+		return err
+	}
+
+	return pb.ctx.SetAttributeValue(pb.session, pub, label)
+}
+
+func (ps *pkcs11Signer) Public() crypto.PublicKey {
+	return ps.pub
+}
+
+// Sign signs message with the token. Like ed25519.PrivateKey, ed25519 keys
+// only support pure Ed25519, so opts must not ask for a hash. ecdsa & rsa keys
+// sign a digest, rsa only with PKCS #1 v1.5.
+func (ps *pkcs11Signer) Sign(_ io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+	switch ps.pub.(type) {
+	case ed25519.PublicKey:
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, &HashedMessageError{}
+		}
+
+		mech = pkcs11.NewMechanism(ckmEdDSA, nil)
+	case *ecdsa.PublicKey:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, &UnsupportedSignatureError{reason: "RSA-PSS"}
+		}
+
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, &UnsupportedSignatureError{reason: fmt.Sprintf("RSA with %s", opts.HashFunc())}
+		}
+
+		message = append(append([]byte{}, prefix...), message...)
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+	}
+
+	pb := ps.backend
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if err := pb.ctx.SignInit(pb.session, []*pkcs11.Mechanism{mech}, ps.key); err != nil {
+		return nil, err
+	}
+
+	sig, err := pb.ctx.Sign(pb.session, message)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ps.pub.(*ecdsa.PublicKey); ok {
+		return ecdsaASN1(sig)
+	}
+
+	return sig, nil
+}
+
+// ecdsaASN1 converts the r || s signature CKM_ECDSA produces into the ASN.1
+// form x509 expects
+func ecdsaASN1(sig []byte) ([]byte, error) {
+	half := len(sig) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(sig[:half]),
+		S: new(big.Int).SetBytes(sig[half:]),
+	})
+}
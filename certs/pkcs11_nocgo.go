@@ -0,0 +1,15 @@
+//go:build !cgo
+
+package certs
+
+import "github.com/spf13/viper"
+
+type PKCS11UnavailableError struct{}
+
+func (e *PKCS11UnavailableError) Error() string {
+	return "PKCS#11 support needs zcert to be built with cgo"
+}
+
+func newPKCS11Backend(conf *viper.Viper) (SignerBackend, error) {
+	return nil, &PKCS11UnavailableError{}
+}
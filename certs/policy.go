@@ -0,0 +1,159 @@
+package certs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PolicyViolation is implemented by errors caused by a request breaking the
+// configured issuance policy rather than by an internal failure. These are
+// safe to report back to the client.
+type PolicyViolation interface {
+	error
+	PolicyViolation()
+}
+
+type KeyAlgorithmError struct {
+	algorithm string
+}
+
+type RSAKeySizeError struct {
+	bits int
+	min  int
+}
+
+type CurveError struct {
+	curve string
+}
+
+type BlockedKeyError struct {
+	fingerprint string
+}
+
+func (e *KeyAlgorithmError) Error() string {
+	return fmt.Sprintf("key algorithm %s is not allowed", e.algorithm)
+}
+
+func (e *RSAKeySizeError) Error() string {
+	return fmt.Sprintf("rsa key is %d bits, at least %d bits are required", e.bits, e.min)
+}
+
+func (e *CurveError) Error() string {
+	return fmt.Sprintf("curve %s is not allowed", e.curve)
+}
+
+func (e *BlockedKeyError) Error() string {
+	return fmt.Sprintf("public key %s is blocklisted", e.fingerprint)
+}
+
+func (e *KeyAlgorithmError) PolicyViolation() {}
+func (e *RSAKeySizeError) PolicyViolation()   {}
+func (e *CurveError) PolicyViolation()        {}
+func (e *BlockedKeyError) PolicyViolation()   {}
+
+// SPKIFingerprint returns the hex encoded sha256 of the DER encoded
+// SubjectPublicKeyInfo of pub
+func SPKIFingerprint(pub interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func keyAlgorithm(pub interface{}) string {
+	switch pub.(type) {
+	case ed25519.PublicKey:
+		return "ed25519"
+	case *ecdsa.PublicKey:
+		return "ecdsa"
+	case *rsa.PublicKey:
+		return "rsa"
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *Authority) keyBlocklist() ([]string, error) {
+	blocklist := a.conf.GetStringSlice("policy.keys.blocklist")
+
+	path := a.conf.GetString("policy.keys.blocklist_file")
+	if len(path) == 0 {
+		return blocklist, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		blocklist = append(blocklist, line)
+	}
+
+	return blocklist, scanner.Err()
+}
+
+// CheckKeyPolicy checks pub against the policy.keys configuration
+func (a *Authority) CheckKeyPolicy(pub interface{}) error {
+	algorithm := keyAlgorithm(pub)
+	if !containsFold(a.conf.GetStringSlice("policy.keys.algorithms"), algorithm) {
+		return &KeyAlgorithmError{algorithm: algorithm}
+	}
+
+	switch v := pub.(type) {
+	case *rsa.PublicKey:
+		min := a.conf.GetInt("policy.keys.rsa_min_bits")
+		if v.N.BitLen() < min {
+			return &RSAKeySizeError{bits: v.N.BitLen(), min: min}
+		}
+	case *ecdsa.PublicKey:
+		curve := v.Curve.Params().Name
+		if !containsFold(a.conf.GetStringSlice("policy.keys.curves"), curve) {
+			return &CurveError{curve: curve}
+		}
+	}
+
+	fingerprint, err := SPKIFingerprint(pub)
+	if err != nil {
+		return err
+	}
+
+	blocklist, err := a.keyBlocklist()
+	if err != nil {
+		return err
+	}
+
+	if containsFold(blocklist, fingerprint) {
+		return &BlockedKeyError{fingerprint: fingerprint}
+	}
+
+	return nil
+}
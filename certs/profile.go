@@ -0,0 +1,72 @@
+package certs
+
+import (
+	"fmt"
+)
+
+const DefaultProfile = "default"
+
+// what to do when a CSR's public key has already been certified
+const (
+	KeyReuseWarn   = "warn"
+	KeyReuseRefuse = "refuse"
+	KeyReuseRevoke = "revoke"
+)
+
+type Profile struct {
+	Name string `mapstructure:"-"`
+
+	KeyReuse string        `mapstructure:"key_reuse"`
+	Subject  SubjectPolicy `mapstructure:"subject"`
+}
+
+type UnknownProfileError struct {
+	name string
+}
+
+type InvalidProfileError struct {
+	name   string
+	reason string
+}
+
+func (e *UnknownProfileError) Error() string {
+	return fmt.Sprintf("unknown profile %s", e.name)
+}
+
+func (e *UnknownProfileError) PolicyViolation() {}
+
+func (e *InvalidProfileError) Error() string {
+	return fmt.Sprintf("profile %s is misconfigured: %s", e.name, e.reason)
+}
+
+// LoadProfile reads the named profile from profiles.<name>. The default
+// profile is used for an empty name and always exists, even when it is not
+// configured.
+func (a *Authority) LoadProfile(name string) (*Profile, error) {
+	if len(name) == 0 {
+		name = DefaultProfile
+	}
+
+	key := fmt.Sprintf("profiles.%s", name)
+	if name != DefaultProfile && !a.conf.IsSet(key) {
+		return nil, &UnknownProfileError{name: name}
+	}
+
+	profile := &Profile{
+		KeyReuse: KeyReuseWarn,
+	}
+
+	if err := a.conf.UnmarshalKey(key, profile); err != nil {
+		return nil, err
+	}
+
+	profile.Name = name
+
+	switch profile.KeyReuse {
+	case KeyReuseWarn, KeyReuseRefuse, KeyReuseRevoke:
+	default:
+		return nil, &InvalidProfileError{name: name, reason: fmt.Sprintf("unknown key_reuse %q", profile.KeyReuse)}
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,97 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type IntermediateRenewError struct{}
+
+type CALifetimeError struct {
+	lifetime time.Duration
+}
+
+func (e *IntermediateRenewError) Error() string {
+	return "only self-signed certificate authorities can be renewed, have the root sign a new intermediate with zcert init --intermediate instead"
+}
+
+func (e *CALifetimeError) Error() string {
+	return fmt.Sprintf("certificate authority lifetime %s has to be positive", e.lifetime)
+}
+
+// Renew re-issues the self-signed certificate authority with the same key,
+// subject and subject key id, valid for lifetime from now. Certificates it
+// issued keep chaining to the renewed certificate. The server has to be
+// restarted to serve it.
+func (a *Authority) Renew(lifetime time.Duration) error {
+	if lifetime <= 0 {
+		return &CALifetimeError{lifetime: lifetime}
+	}
+
+	if !isSelfSigned(a.Cert) {
+		return &IntermediateRenewError{}
+	}
+
+	template, err := reissueTemplate(a.Cert)
+	if err != nil {
+		return err
+	}
+
+	template.NotAfter = template.NotBefore.Add(lifetime)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, a.Cert.PublicKey, a.Signer)
+	if err != nil {
+		return err
+	}
+
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"keyid":    KeyID(crt),
+		"previous": a.Cert.NotAfter,
+		"expires":  crt.NotAfter,
+	}).Info("renewing certificate authority")
+
+	if err = checkFile(a.storagePath("ca.crt"), true); err != nil {
+		return err
+	}
+
+	if err = writeCert(a.storagePath("ca.crt"), crt); err != nil {
+		return err
+	}
+
+	a.Cert = crt
+	return nil
+}
+
+// WarnExpiry logs a warning when the certificate authority expires within
+// ca.expiry_warning
+func (a *Authority) WarnExpiry() {
+	remaining := time.Until(a.Cert.NotAfter)
+	if remaining > a.conf.GetDuration("ca.expiry_warning") {
+		return
+	}
+
+	fields := log.Fields{
+		"keyid":   KeyID(a.Cert),
+		"expires": a.Cert.NotAfter,
+	}
+
+	if len(a.Name) > 0 {
+		fields["ca"] = a.Name
+	}
+
+	if remaining <= 0 {
+		log.WithFields(fields).Error("certificate authority has expired, renew it with zcert ca renew")
+		return
+	}
+
+	log.WithFields(fields).Warn("certificate authority expires soon, renew it with zcert ca renew")
+}
@@ -0,0 +1,115 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/zcert/db"
+)
+
+// baselineAuthority returns a self-signed certificate authority on disk whose
+// certificate is made the way zcert made them before CRLs, without the CRL
+// signing key usage
+func baselineAuthority(t *testing.T) *Authority {
+	dir := t.TempDir()
+
+	conf := viper.New()
+	conf.Set("storage.path", dir)
+	conf.Set("ca.name", "baseline ca")
+	conf.Set("ca.signer", SignerFile)
+	conf.Set("ca.keytype", "ed25519")
+	conf.Set("lifetime", time.Hour*24)
+	conf.Set("crl.lifetime", time.Hour)
+
+	a := &Authority{conf: conf}
+	if err := a.CreateCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := a.loadKey("ca.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "baseline ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = checkFile(a.storagePath("ca.crt"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writeCert(a.storagePath("ca.crt"), crt); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.DB, err = db.Open(filepath.Join(dir, "db.sqlite3"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.LoadCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func TestRenewAddsCRLSign(t *testing.T) {
+	a := baselineAuthority(t)
+	keyID := KeyID(a.Cert)
+
+	if err := a.Renew(time.Hour * 48); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Cert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		t.Fatalf("renewed certificate authority has key usage %d, without crl signing", a.Cert.KeyUsage)
+	}
+
+	if KeyID(a.Cert) != keyID {
+		t.Fatalf("renewing changed the key id from %s to %s", keyID, KeyID(a.Cert))
+	}
+}
+
+func TestRenewLifetime(t *testing.T) {
+	for _, lifetime := range []time.Duration{0, -time.Hour} {
+		a := baselineAuthority(t)
+		crt := a.Cert
+
+		err := a.Renew(lifetime)
+		if _, ok := err.(*CALifetimeError); !ok {
+			t.Fatalf("renewing for %s returned %v, expected a lifetime error", lifetime, err)
+		}
+
+		if err = a.LoadCA(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !a.Cert.Equal(crt) {
+			t.Fatalf("renewing for %s replaced ca.crt", lifetime)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package certs
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/db"
+	"gorm.io/gorm"
+)
+
+type KeyReusedError struct {
+	fingerprint string
+	serial      string
+}
+
+type CompromisedKeyError struct {
+	fingerprint string
+	serial      string
+}
+
+func (e *KeyReusedError) Error() string {
+	return fmt.Sprintf("public key %s was already certified in certificate %s", e.fingerprint, e.serial)
+}
+
+func (e *CompromisedKeyError) Error() string {
+	return fmt.Sprintf("public key %s was reported compromised when certificate %s was revoked", e.fingerprint, e.serial)
+}
+
+func (e *KeyReusedError) PolicyViolation()      {}
+func (e *CompromisedKeyError) PolicyViolation() {}
+
+// checkKeyReuse looks for earlier certificates for the same public key and
+// applies the profile's key_reuse setting. It returns the certificates that
+// should be revoked once the new certificate has been issued.
+func checkKeyReuse(tx *gorm.DB, fingerprint string, profile *Profile) ([]db.SignedCertificate, error) {
+	previous, err := db.CertsForKey(tx, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	var supersede []db.SignedCertificate
+	for _, prev := range previous {
+		if prev.Revoked && prev.RevocationReason == db.ReasonKeyCompromise {
+			return nil, &CompromisedKeyError{fingerprint: fingerprint, serial: prev.Serial}
+		}
+
+		switch profile.KeyReuse {
+		case KeyReuseRefuse:
+			return nil, &KeyReusedError{fingerprint: fingerprint, serial: prev.Serial}
+		case KeyReuseRevoke:
+			if !prev.Revoked && prev.NotAfter.After(time.Now()) {
+				supersede = append(supersede, prev)
+			}
+		}
+
+		log.WithFields(log.Fields{
+			"fingerprint": fingerprint,
+			"serial":      prev.Serial,
+			"revoked":     prev.Revoked,
+			"profile":     profile.Name,
+		}).Warn("public key has already been certified")
+	}
+
+	return supersede, nil
+}
@@ -0,0 +1,336 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+// RetiredCA is a certificate authority that was replaced by a rollover. Its
+// key is kept to sign CRLs for the certificates it issued until it expires.
+type RetiredCA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+
+	// NewByOld is its successor signed by this CA, and OldByNew is this CA
+	// signed by its successor
+	NewByOld *x509.Certificate
+	OldByNew *x509.Certificate
+}
+
+type IntermediateRolloverError struct{}
+
+func (e *IntermediateRolloverError) Error() string {
+	return "only self-signed certificate authorities can be rolled over, create a new intermediate with zcert init --intermediate instead"
+}
+
+// retiredName is the name of a retired CA's file relative to storage.path
+func retiredName(keyID, name string) string {
+	return fmt.Sprintf("retired/%s/%s", keyID, name)
+}
+
+func (a *Authority) retiredPath(keyID, name string) string {
+	return a.storagePath(retiredName(keyID, name))
+}
+
+func (a *Authority) loadRetired() error {
+	a.Retired = nil
+
+	entries, err := os.ReadDir(a.storagePath("retired"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		keyID := entry.Name()
+		retired := &RetiredCA{}
+
+		retired.Cert, err = util.DecodeX509CertFromPath(a.retiredPath(keyID, "ca.crt"))
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(retired.Cert.NotAfter) {
+			log.WithFields(log.Fields{
+				"keyID":    keyID,
+				"notAfter": retired.Cert.NotAfter,
+			}).Debug("skipping expired retired certificate authority")
+
+			continue
+		}
+
+		retired.Key, err = a.loadKey(retiredName(keyID, "ca.key"))
+		if err != nil {
+			return err
+		}
+
+		if err = checkKey(retiredName(keyID, "ca.key"), retired.Key, retired.Cert); err != nil {
+			return err
+		}
+
+		retired.NewByOld, err = util.DecodeX509CertFromPath(a.retiredPath(keyID, "new-by-old.crt"))
+		if err != nil {
+			return err
+		}
+
+		retired.OldByNew, err = util.DecodeX509CertFromPath(a.retiredPath(keyID, "old-by-new.crt"))
+		if err != nil {
+			return err
+		}
+
+		a.Retired = append(a.Retired, retired)
+	}
+
+	return nil
+}
+
+// TrustBundle returns the certificates served from /ca: the trust chain of the
+// current CA followed by, for every retired CA that still has unexpired
+// certificates, the retired CA and both cross-signed certificates
+func (a *Authority) TrustBundle() ([]*x509.Certificate, error) {
+	bundle := a.TrustChain()
+
+	for _, retired := range a.Retired {
+		inUse, err := db.HasUnexpired(a.DB, KeyID(retired.Cert))
+		if err != nil {
+			return nil, err
+		}
+
+		if inUse {
+			bundle = append(bundle, retired.Cert, retired.NewByOld, retired.OldByNew)
+		}
+	}
+
+	return bundle, nil
+}
+
+// Issued reports whether crt was signed by the authority or by one of the
+// certificate authorities it replaced
+func (a *Authority) Issued(crt *x509.Certificate) bool {
+	if crt.CheckSignatureFrom(a.Cert) == nil {
+		return true
+	}
+
+	for _, retired := range a.Retired {
+		if crt.CheckSignatureFrom(retired.Cert) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reissueTemplate copies what makes crt a certificate authority: its subject,
+// subject key id, usages and constraints. Certificates issued from the copy
+// can stand in for crt when building chains. Older zcert versions left out
+// the CRL signing usage, so it's added along with cert signing.
+func reissueTemplate(crt *x509.Certificate) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), randomSerialBits))
+	if err != nil {
+		return nil, err
+	}
+
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               crt.Subject,
+		SubjectKeyId:          crt.SubjectKeyId,
+		NotBefore:             time.Now(),
+		NotAfter:              crt.NotAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            crt.MaxPathLen,
+		MaxPathLenZero:        crt.MaxPathLenZero,
+		KeyUsage:              crt.KeyUsage | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           crt.ExtKeyUsage,
+
+		PermittedDNSDomainsCritical: crt.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         crt.PermittedDNSDomains,
+		ExcludedDNSDomains:          crt.ExcludedDNSDomains,
+		PermittedIPRanges:           crt.PermittedIPRanges,
+		ExcludedIPRanges:            crt.ExcludedIPRanges,
+		PermittedEmailAddresses:     crt.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      crt.ExcludedEmailAddresses,
+		PermittedURIDomains:         crt.PermittedURIDomains,
+		ExcludedURIDomains:          crt.ExcludedURIDomains,
+	}, nil
+}
+
+// crossSign signs a copy of crt for pub with signer, keeping crt's subject and
+// subject key id so that it can stand in for crt when building chains
+func crossSign(crt *x509.Certificate, pub crypto.PublicKey, signer *x509.Certificate, signerKey crypto.Signer) (*x509.Certificate, error) {
+	template, err := reissueTemplate(crt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Go leaves out the authority key id when the issuer and subject names
+	// match, which they usually do here. Without it the cross-signed
+	// certificate looks self-signed.
+	template.AuthorityKeyId = signer.SubjectKeyId
+
+	if template.NotAfter.After(signer.NotAfter) {
+		template.NotAfter = signer.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, pub, signerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// withCRLSign returns crt, or when it can't sign CRLs a copy of it with the
+// CRL signing usage, self-signed with key. Certificates issued by crt verify
+// against the copy as it keeps the subject, key and subject key id.
+func withCRLSign(crt *x509.Certificate, key crypto.Signer) (*x509.Certificate, error) {
+	if canSignCRLs(crt) {
+		return crt, nil
+	}
+
+	template, err := reissueTemplate(crt)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, crt.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"keyid": KeyID(crt),
+	}).Info("re-issuing certificate authority with the crl signing usage")
+
+	return x509.ParseCertificate(der)
+}
+
+func writeCert(path string, crt *x509.Certificate) error {
+	buf := new(bytes.Buffer)
+	if err := util.EncodeX509Cert(buf, crt.Raw); err != nil {
+		return err
+	}
+
+	return writeFile(path, buf, 0644)
+}
+
+// Rollover replaces the current certificate authority with a new key and
+// certificate. The two are cross-signed, and the old one is moved to
+// retired/<key id> where it is kept until it expires. The new key is created
+// by the signer backend. The server has to be restarted to start issuing from
+// the new CA.
+func (a *Authority) Rollover(lifetime time.Duration) error {
+	if lifetime <= 0 {
+		return &CALifetimeError{lifetime: lifetime}
+	}
+
+	if !isSelfSigned(a.Cert) {
+		return &IntermediateRolloverError{}
+	}
+
+	// the retired CA keeps signing CRLs, which CAs made by older versions
+	// of zcert weren't allowed to
+	oldCrt, err := withCRLSign(a.Cert, a.Signer)
+	if err != nil {
+		return err
+	}
+
+	oldKey := a.Signer
+	oldKeyID := KeyID(oldCrt)
+
+	// anything not yet attributed to an issuer came from the CA being retired
+	if err = db.ClaimLegacyCerts(a.DB, oldKeyID); err != nil {
+		return err
+	}
+
+	template, err := a.caTemplate(oldCrt.Subject.CommonName, lifetime)
+	if err != nil {
+		return err
+	}
+
+	template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), randomSerialBits))
+	if err != nil {
+		return err
+	}
+
+	b, err := a.Backend()
+	if err != nil {
+		return err
+	}
+
+	// retire the old CA before generating the new key, so that a failure
+	// never loses the old key
+	retiredDir := a.storagePath(retiredName(oldKeyID, ""))
+	if err = os.MkdirAll(retiredDir, 0700); err != nil {
+		return err
+	}
+
+	if err = b.RenameKey("ca.key", retiredName(oldKeyID, "ca.key")); err != nil {
+		os.Remove(retiredDir)
+		return err
+	}
+
+	if err = writeCert(a.retiredPath(oldKeyID, "ca.crt"), oldCrt); err != nil {
+		return err
+	}
+
+	newKey, err := b.GenerateKey("ca.key", a.conf.GetString("ca.keytype"))
+	if err != nil {
+		return err
+	}
+
+	newDER, err := x509.CreateCertificate(rand.Reader, template, template, newKey.Public(), newKey)
+	if err != nil {
+		return err
+	}
+
+	newCrt, err := x509.ParseCertificate(newDER)
+	if err != nil {
+		return err
+	}
+
+	newByOld, err := crossSign(newCrt, newKey.Public(), oldCrt, oldKey)
+	if err != nil {
+		return err
+	}
+
+	oldByNew, err := crossSign(oldCrt, oldCrt.PublicKey, newCrt, newKey)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"old": oldKeyID,
+		"new": KeyID(newCrt),
+	}).Info("rolling over certificate authority")
+
+	if err = writeCert(a.retiredPath(oldKeyID, "new-by-old.crt"), newByOld); err != nil {
+		return err
+	}
+
+	if err = writeCert(a.retiredPath(oldKeyID, "old-by-new.crt"), oldByNew); err != nil {
+		return err
+	}
+
+	if err = checkFile(a.storagePath("ca.crt"), true); err != nil {
+		return err
+	}
+
+	return writeCert(a.storagePath("ca.crt"), newCrt)
+}
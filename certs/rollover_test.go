@@ -0,0 +1,90 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+// TestRolloverCRL rolls over a certificate authority made before zcert set
+// the CRL signing usage, and checks the retired and the new certificate
+// authority can both sign CRLs
+func TestRolloverCRL(t *testing.T) {
+	a := baselineAuthority(t)
+
+	if _, err := a.CreateCRL(a.Cert, a.Signer); err == nil {
+		t.Fatal("created a crl with a certificate authority that may not sign them")
+	}
+
+	signed, err := a.SignCSR(testCSR(t, "www.example.com"), CSRParams{Lifetime: time.Hour, ServerAuth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := util.DecodeX509Cert(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := db.CertBySerial(a.DB, SerialString(leaf.SerialNumber))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = record.Revoke(a.DB, db.ReasonKeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Rollover(time.Hour * 48); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.LoadCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.Retired) != 1 {
+		t.Fatalf("%d retired certificate authorities after a rollover, expected 1", len(a.Retired))
+	}
+
+	retired := a.Retired[0]
+	if err = leaf.CheckSignatureFrom(retired.Cert); err != nil {
+		t.Fatalf("certificate issued before the rollover doesn't verify against the retired certificate authority: %s", err)
+	}
+
+	for _, issuer := range []struct {
+		name    string
+		crt     *x509.Certificate
+		revoked int
+	}{
+		{name: "retired", crt: retired.Cert, revoked: 1},
+		{name: "new", crt: a.Cert, revoked: 0},
+	} {
+		crt, key, err := a.Issuer(KeyID(issuer.crt))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		der, err := a.CreateCRL(crt, key)
+		if err != nil {
+			t.Fatalf("unable to create the %s certificate authority's crl: %s", issuer.name, err)
+		}
+
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = crl.CheckSignatureFrom(crt); err != nil {
+			t.Fatalf("%s certificate authority's crl doesn't verify: %s", issuer.name, err)
+		}
+
+		if len(crl.RevokedCertificates) != issuer.revoked {
+			t.Fatalf("%s certificate authority's crl lists %d certificates, expected %d", issuer.name, len(crl.RevokedCertificates), issuer.revoked)
+		}
+	}
+}
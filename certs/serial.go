@@ -0,0 +1,107 @@
+package certs
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/db"
+	"gorm.io/gorm"
+)
+
+// ways of picking certificate serial numbers
+const (
+	SerialSequential = "sequential"
+	SerialRandom     = "random"
+)
+
+const randomSerialBits = 128
+const maxSerialAttempts = 8
+
+type UnknownSerialStrategyError struct {
+	strategy string
+}
+
+type SerialCollisionError struct {
+	attempts int
+}
+
+func (e *UnknownSerialStrategyError) Error() string {
+	return fmt.Sprintf("unknown ca.serial strategy %q", e.strategy)
+}
+
+func (e *SerialCollisionError) Error() string {
+	return fmt.Sprintf("unable to find an unused serial number after %d attempts", e.attempts)
+}
+
+// SerialString is the encoding used for serial numbers in the database
+func SerialString(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+func (a *Authority) nextSerial(tx *gorm.DB) (*big.Int, error) {
+	switch strategy := a.conf.GetString("ca.serial"); strategy {
+	case "", SerialSequential:
+		serial, err := db.NextSerial(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		return big.NewInt(serial), nil
+	case SerialRandom:
+		return randomSerial(tx)
+	default:
+		return nil, &UnknownSerialStrategyError{strategy: strategy}
+	}
+}
+
+func randomSerial(tx *gorm.DB) (*big.Int, error) {
+	max := new(big.Int).Lsh(big.NewInt(1), randomSerialBits)
+
+	for i := 0; i < maxSerialAttempts; i++ {
+		serial, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+
+		if serial.Sign() == 0 {
+			continue
+		}
+
+		exists, err := db.SerialExists(tx, SerialString(serial))
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return serial, nil
+		}
+
+		log.WithFields(log.Fields{
+			"serial": SerialString(serial),
+		}).Warn("random serial collided with an existing certificate")
+	}
+
+	return nil, &SerialCollisionError{attempts: maxSerialAttempts}
+}
+
+// SeedSerial makes sure sequential serials continue above highest, the
+// largest serial issued by a CA before zcert took it over. Serials too large
+// for the sequential counter are left to ca.serial random, which avoids every
+// serial recorded in the database.
+func (a *Authority) SeedSerial(highest *big.Int) error {
+	if !highest.IsInt64() {
+		log.WithFields(log.Fields{
+			"serial": SerialString(highest),
+		}).Warn("highest imported serial is too large for sequential serials, use ca.serial random")
+
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"serial": SerialString(highest),
+	}).Info("continuing sequential serials above imported serial")
+
+	return db.RaiseSerial(a.DB, highest.Int64())
+}
@@ -0,0 +1,133 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// which SignerBackend holds the CA keys, set by ca.signer
+const (
+	SignerFile   = "file"
+	SignerPKCS11 = "pkcs11"
+	SignerAgent  = "agent"
+)
+
+// SignerBackend stores the private keys of the certificate authorities. Keys
+// are named after the files the file backend keeps them in, relative to
+// storage.path: ca.key, root.key and retired/<key id>/ca.key.
+type SignerBackend interface {
+	// LoadKey returns a signer for an existing key
+	LoadKey(name string) (crypto.Signer, error)
+
+	// GenerateKey creates and stores a new key of keyType
+	GenerateKey(name, keyType string) (crypto.Signer, error)
+
+	// RenameKey moves a key to a new name
+	RenameKey(from, to string) error
+}
+
+type UnknownSignerError struct {
+	name string
+}
+
+type KeyMismatchError struct {
+	name string
+}
+
+func (e *UnknownSignerError) Error() string {
+	return fmt.Sprintf("unknown ca.signer %q", e.name)
+}
+
+func (e *KeyMismatchError) Error() string {
+	return fmt.Sprintf("key %s does not match its certificate", e.name)
+}
+
+// Backend returns the SignerBackend configured by ca.signer
+func (a *Authority) Backend() (SignerBackend, error) {
+	if a.backend != nil {
+		return a.backend, nil
+	}
+
+	switch name := a.conf.GetString("ca.signer"); name {
+	case SignerFile:
+		a.backend = &fileBackend{authority: a}
+	case SignerPKCS11:
+		b, err := newPKCS11Backend(a.conf)
+		if err != nil {
+			return nil, err
+		}
+
+		a.backend = b
+	case SignerAgent:
+		b, err := newAgentBackend(a.AgentSocket())
+		if err != nil {
+			return nil, err
+		}
+
+		a.backend = b
+	default:
+		return nil, &UnknownSignerError{name: name}
+	}
+
+	return a.backend, nil
+}
+
+func (a *Authority) loadKey(name string) (crypto.Signer, error) {
+	b, err := a.Backend()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.LoadKey(name)
+}
+
+// checkKey makes sure key belongs to crt, so that a misconfigured backend is
+// caught before it signs anything
+func checkKey(name string, key crypto.Signer, crt *x509.Certificate) error {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(crt.PublicKey) {
+		return &KeyMismatchError{name: name}
+	}
+
+	return nil
+}
+
+// fileBackend keeps PEM encoded keys in storage.path, encrypted when a
+// passphrase is set
+type fileBackend struct {
+	authority *Authority
+}
+
+func (fb *fileBackend) LoadKey(name string) (crypto.Signer, error) {
+	key, err := fb.authority.decodeKey(fb.authority.storagePath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (fb *fileBackend) GenerateKey(name, keyType string) (crypto.Signer, error) {
+	key, err := generateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = fb.authority.encodeKey(buf, key); err != nil {
+		return nil, err
+	}
+
+	if err = writeFile(fb.authority.storagePath(name), buf, 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (fb *fileBackend) RenameKey(from, to string) error {
+	return os.Rename(fb.authority.storagePath(from), fb.authority.storagePath(to))
+}
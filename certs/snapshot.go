@@ -0,0 +1,556 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+// formats an inventory snapshot can be written in
+const (
+	SnapshotJSON = "json"
+	SnapshotCSV  = "csv"
+)
+
+const snapshotVersion = 1
+
+// SnapshotRow is a recorded certificate as it appears in a snapshot. Every
+// value is a string or a bool, so JSON and CSV snapshots hold the same thing.
+type SnapshotRow struct {
+	Serial    string `json:"serial"`
+	NotBefore string `json:"not_before"`
+	NotAfter  string `json:"not_after"`
+
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+
+	AuthorityKeyID string `json:"authority_key_id"`
+
+	SANs    []string `json:"sans"`
+	NameSet string   `json:"name_set"`
+
+	ClientAuth bool `json:"client_auth"`
+	ServerAuth bool `json:"server_auth"`
+	IsCA       bool `json:"is_ca"`
+
+	SPKIFingerprint string `json:"spki_fingerprint"`
+
+	Revoked          bool   `json:"revoked"`
+	RevokedAt        string `json:"revoked_at"`
+	RevocationReason string `json:"revocation_reason"`
+}
+
+// SnapshotManifest is the detached signature of a snapshot. It covers the
+// snapshot's digest, when it was taken, how many rows it holds, and the
+// certificate authority that signed it.
+type SnapshotManifest struct {
+	Version   int    `json:"version"`
+	Format    string `json:"format"`
+	CreatedAt string `json:"created_at"`
+	Rows      int    `json:"rows"`
+	SHA256    string `json:"sha256"`
+
+	CACertificate string `json:"ca_certificate"`
+
+	SignatureAlgorithm string `json:"signature_algorithm"`
+	Signature          string `json:"signature"`
+}
+
+type UnknownSnapshotFormatError struct {
+	format string
+}
+
+type InvalidSnapshotError struct {
+	reason string
+}
+
+type SnapshotDigestError struct{}
+
+type SnapshotRowCountError struct {
+	expected int
+	found    int
+}
+
+type SnapshotCAMismatchError struct{}
+
+type SnapshotUntrustedError struct{}
+
+type SnapshotSignerChangedError struct{}
+
+type SnapshotSignatureError struct {
+	err error
+}
+
+type SnapshotOrderError struct{}
+
+type SnapshotKeyError struct {
+	key crypto.PublicKey
+}
+
+func (e *UnknownSnapshotFormatError) Error() string {
+	return fmt.Sprintf("unknown snapshot format %q, use json or csv", e.format)
+}
+
+func (e *InvalidSnapshotError) Error() string {
+	return fmt.Sprintf("invalid snapshot: %s", e.reason)
+}
+
+func (e *SnapshotDigestError) Error() string {
+	return "snapshot doesn't match the digest in its signature"
+}
+
+func (e *SnapshotRowCountError) Error() string {
+	return fmt.Sprintf("snapshot holds %d rows, its signature says %d", e.found, e.expected)
+}
+
+func (e *SnapshotCAMismatchError) Error() string {
+	return "snapshot was signed by a different certificate authority"
+}
+
+func (e *SnapshotUntrustedError) Error() string {
+	return "snapshots can only be verified against a trusted certificate authority"
+}
+
+func (e *SnapshotSignerChangedError) Error() string {
+	return "snapshots were signed by different certificate authorities"
+}
+
+func (e *SnapshotSignatureError) Error() string {
+	return fmt.Sprintf("invalid snapshot signature: %s", e.err)
+}
+
+func (e *SnapshotSignatureError) Unwrap() error {
+	return e.err
+}
+
+func (e *SnapshotKeyError) Error() string {
+	return fmt.Sprintf("can't sign snapshots with %T keys", e.key)
+}
+
+func (e *SnapshotOrderError) Error() string {
+	return "previous snapshot was taken after the current one"
+}
+
+var snapshotHeader = []string{
+	"serial", "not_before", "not_after", "issuer", "subject", "authority_key_id",
+	"sans", "name_set", "client_auth", "server_auth", "is_ca", "spki_fingerprint",
+	"revoked", "revoked_at", "revocation_reason",
+}
+
+func snapshotTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+func snapshotRow(sc *db.SignedCertificate) SnapshotRow {
+	sans := sc.SANs
+	if sans == nil {
+		sans = []string{}
+	}
+
+	return SnapshotRow{
+		Serial:    sc.Serial,
+		NotBefore: snapshotTime(sc.NotBefore),
+		NotAfter:  snapshotTime(sc.NotAfter),
+
+		Issuer:  sc.Issuer.String(),
+		Subject: sc.Subject.String(),
+
+		AuthorityKeyID: sc.AuthorityKeyID,
+
+		SANs:    sans,
+		NameSet: sc.NameSet,
+
+		ClientAuth: sc.ClientAuth,
+		ServerAuth: sc.ServerAuth,
+		IsCA:       sc.IsCA,
+
+		SPKIFingerprint: sc.SPKIFingerprint,
+
+		Revoked:          sc.Revoked,
+		RevokedAt:        snapshotTime(sc.RevokedAt),
+		RevocationReason: sc.RevocationReason,
+	}
+}
+
+// fields returns the row's values in the order of snapshotHeader
+func (r SnapshotRow) fields() []string {
+	return []string{
+		r.Serial, r.NotBefore, r.NotAfter, r.Issuer, r.Subject, r.AuthorityKeyID,
+		strings.Join(r.SANs, " "), r.NameSet, strconv.FormatBool(r.ClientAuth),
+		strconv.FormatBool(r.ServerAuth), strconv.FormatBool(r.IsCA), r.SPKIFingerprint,
+		strconv.FormatBool(r.Revoked), r.RevokedAt, r.RevocationReason,
+	}
+}
+
+// rowFromFields parses line n of a csv snapshot
+func rowFromFields(n int, fields []string) (SnapshotRow, error) {
+	if len(fields) != len(snapshotHeader) {
+		return SnapshotRow{}, &InvalidSnapshotError{reason: fmt.Sprintf("csv line %d has %d columns, expected %d", n, len(fields), len(snapshotHeader))}
+	}
+
+	bools := make([]bool, 4)
+	for i, column := range []int{8, 9, 10, 12} {
+		b, err := strconv.ParseBool(fields[column])
+		if err != nil {
+			return SnapshotRow{}, &InvalidSnapshotError{reason: fmt.Sprintf("csv line %d: %s", n, err)}
+		}
+
+		bools[i] = b
+	}
+
+	sans := []string{}
+	if len(fields[6]) > 0 {
+		sans = strings.Split(fields[6], " ")
+	}
+
+	return SnapshotRow{
+		Serial:           fields[0],
+		NotBefore:        fields[1],
+		NotAfter:         fields[2],
+		Issuer:           fields[3],
+		Subject:          fields[4],
+		AuthorityKeyID:   fields[5],
+		SANs:             sans,
+		NameSet:          fields[7],
+		ClientAuth:       bools[0],
+		ServerAuth:       bools[1],
+		IsCA:             bools[2],
+		SPKIFingerprint:  fields[11],
+		Revoked:          bools[3],
+		RevokedAt:        fields[13],
+		RevocationReason: fields[14],
+	}, nil
+}
+
+func encodeSnapshot(w io.Writer, format string, rows []SnapshotRow) error {
+	switch format {
+	case SnapshotJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case SnapshotCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(snapshotHeader); err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err := writer.Write(row.fields()); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	default:
+		return &UnknownSnapshotFormatError{format: format}
+	}
+}
+
+func decodeSnapshot(data []byte, format string) ([]SnapshotRow, error) {
+	switch format {
+	case SnapshotJSON:
+		var rows []SnapshotRow
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, err
+		}
+
+		return rows, nil
+	case SnapshotCSV:
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(records) == 0 || strings.Join(records[0], ",") != strings.Join(snapshotHeader, ",") {
+			return nil, &InvalidSnapshotError{reason: "csv doesn't start with the expected header"}
+		}
+
+		rows := make([]SnapshotRow, 0, len(records)-1)
+		for i, record := range records[1:] {
+			row, err := rowFromFields(i+2, record)
+			if err != nil {
+				return nil, err
+			}
+
+			rows = append(rows, row)
+		}
+
+		return rows, nil
+	default:
+		return nil, &UnknownSnapshotFormatError{format: format}
+	}
+}
+
+// signedMessage is what the manifest's signature covers
+func (m *SnapshotManifest) signedMessage(caDER []byte) []byte {
+	caSum := sha256.Sum256(caDER)
+	return []byte(fmt.Sprintf("zcert snapshot v%d\nformat: %s\ncreated_at: %s\nrows: %d\nsha256: %s\nca: %s\n",
+		m.Version, m.Format, m.CreatedAt, m.Rows, m.SHA256, hex.EncodeToString(caSum[:])))
+}
+
+// signatureAlgorithm picks how key signs snapshots, so that they can be
+// checked with x509.Certificate.CheckSignature
+func signatureAlgorithm(pub crypto.PublicKey) (x509.SignatureAlgorithm, crypto.Hash, error) {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return x509.PureEd25519, crypto.Hash(0), nil
+	case *ecdsa.PublicKey:
+		if pub.Curve == elliptic.P384() {
+			return x509.ECDSAWithSHA384, crypto.SHA384, nil
+		}
+
+		return x509.ECDSAWithSHA256, crypto.SHA256, nil
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, crypto.SHA256, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, 0, &SnapshotKeyError{key: pub}
+	}
+}
+
+// Snapshot writes every recorded certificate to w in format, ordered as they
+// were recorded, and returns the manifest signed with the certificate
+// authority's key
+func (a *Authority) Snapshot(w io.Writer, format string) (*SnapshotManifest, error) {
+	records, err := db.AllCertificates(a.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]SnapshotRow, len(records))
+	for i := range records {
+		rows[i] = snapshotRow(&records[i])
+	}
+
+	digest := sha256.New()
+	if err = encodeSnapshot(io.MultiWriter(w, digest), format, rows); err != nil {
+		return nil, err
+	}
+
+	caBuf := new(bytes.Buffer)
+	if err = util.EncodeX509Cert(caBuf, a.Cert.Raw); err != nil {
+		return nil, err
+	}
+
+	algorithm, hash, err := signatureAlgorithm(a.Signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &SnapshotManifest{
+		Version:   snapshotVersion,
+		Format:    format,
+		CreatedAt: snapshotTime(time.Now()),
+		Rows:      len(rows),
+		SHA256:    hex.EncodeToString(digest.Sum(nil)),
+
+		CACertificate:      caBuf.String(),
+		SignatureAlgorithm: algorithm.String(),
+	}
+
+	signed := manifest.signedMessage(a.Cert.Raw)
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		signed = h.Sum(nil)
+	}
+
+	signature, err := a.Signer.Sign(rand.Reader, signed, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Signature = util.EncodeB64(signature)
+	return manifest, nil
+}
+
+// SnapshotSigners returns the certificates of the authority and of the
+// certificate authorities it replaced, to verify snapshots with. They're read
+// from storage.path without loading any keys.
+func (a *Authority) SnapshotSigners() ([]*x509.Certificate, error) {
+	crt, err := util.DecodeX509CertFromPath(a.storagePath("ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	signers := []*x509.Certificate{crt}
+
+	entries, err := os.ReadDir(a.storagePath("retired"))
+	if errors.Is(err, os.ErrNotExist) {
+		return signers, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		retired, err := util.DecodeX509CertFromPath(a.retiredPath(entry.Name(), "ca.crt"))
+		if err != nil {
+			return nil, err
+		}
+
+		signers = append(signers, retired)
+	}
+
+	return signers, nil
+}
+
+func samePublicKey(a, b crypto.PublicKey) bool {
+	pub, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(b)
+}
+
+// VerifySnapshot checks data against its manifest and returns its rows. The
+// manifest's certificate authority has to have the key of one of trusted, the
+// certificate included in the manifest is never trusted on its own.
+func VerifySnapshot(data []byte, manifest *SnapshotManifest, trusted []*x509.Certificate) ([]SnapshotRow, error) {
+	if len(trusted) == 0 {
+		return nil, &SnapshotUntrustedError{}
+	}
+
+	ca, err := util.DecodeX509Cert(strings.NewReader(manifest.CACertificate))
+	if err != nil {
+		return nil, err
+	}
+
+	known := false
+	for _, crt := range trusted {
+		known = known || samePublicKey(crt.PublicKey, ca.PublicKey)
+	}
+
+	if !known {
+		return nil, &SnapshotCAMismatchError{}
+	}
+
+	algorithm, _, err := signatureAlgorithm(ca.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := util.DecodeB64(manifest.Signature)
+	if err != nil {
+		return nil, &SnapshotSignatureError{err: err}
+	}
+
+	if err = ca.CheckSignature(algorithm, manifest.signedMessage(ca.Raw), signature); err != nil {
+		return nil, &SnapshotSignatureError{err: err}
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		return nil, &SnapshotDigestError{}
+	}
+
+	rows, err := decodeSnapshot(data, manifest.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) != manifest.Rows {
+		return nil, &SnapshotRowCountError{expected: manifest.Rows, found: len(rows)}
+	}
+
+	return rows, nil
+}
+
+// CheckSnapshotSigners makes sure two snapshots were signed by the same
+// certificate authority key, so that they can be compared
+func CheckSnapshotSigners(previous, current *SnapshotManifest) error {
+	previousCA, err := util.DecodeX509Cert(strings.NewReader(previous.CACertificate))
+	if err != nil {
+		return err
+	}
+
+	currentCA, err := util.DecodeX509Cert(strings.NewReader(current.CACertificate))
+	if err != nil {
+		return err
+	}
+
+	if !samePublicKey(previousCA.PublicKey, currentCA.PublicKey) {
+		return &SnapshotSignerChangedError{}
+	}
+
+	return nil
+}
+
+// SnapshotDiff is what changed between two snapshots. Certificates being
+// added and revoked is expected, anything else is listed in Unexpected.
+type SnapshotDiff struct {
+	Added      []string
+	Revoked    []string
+	Unexpected []string
+}
+
+// CompareSnapshots compares the rows of a snapshot with the rows of an older
+// one, taken at previousAt & currentAt
+func CompareSnapshots(previous, current []SnapshotRow, previousAt, currentAt string) (*SnapshotDiff, error) {
+	if previousAt > currentAt {
+		return nil, &SnapshotOrderError{}
+	}
+
+	diff := &SnapshotDiff{}
+	seen := map[string]SnapshotRow{}
+	for _, row := range current {
+		seen[row.Serial] = row
+	}
+
+	old := map[string]bool{}
+	for _, prev := range previous {
+		old[prev.Serial] = true
+
+		row, ok := seen[prev.Serial]
+		if !ok {
+			diff.Unexpected = append(diff.Unexpected, fmt.Sprintf("serial %s was removed", prev.Serial))
+			continue
+		}
+
+		if !prev.Revoked && row.Revoked {
+			diff.Revoked = append(diff.Revoked, row.Serial)
+
+			// the revocation itself is expected, anything else changing isn't
+			row.Revoked, row.RevokedAt, row.RevocationReason = false, "", ""
+		}
+
+		prevFields, fields := prev.fields(), row.fields()
+		for i := range fields {
+			if fields[i] != prevFields[i] {
+				diff.Unexpected = append(diff.Unexpected, fmt.Sprintf("serial %s: %s changed from %q to %q",
+					prev.Serial, snapshotHeader[i], prevFields[i], fields[i]))
+			}
+		}
+	}
+
+	for _, row := range current {
+		if !old[row.Serial] {
+			diff.Added = append(diff.Added, row.Serial)
+		}
+	}
+
+	return diff, nil
+}
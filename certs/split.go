@@ -0,0 +1,172 @@
+package certs
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/util"
+)
+
+const splitKeySize = 32
+
+func (a *Authority) unlockSocket() string {
+	if path := a.conf.GetString("ca.unlock_socket"); len(path) > 0 {
+		return path
+	}
+
+	return a.storagePath("unlock.sock")
+}
+
+// Split seals the keys in paths under a new random key and splits that key
+// into shares, threshold of which are needed to unlock the keys again. The
+// shares are returned base64 encoded and are not stored anywhere.
+func (a *Authority) Split(paths []string, shares, threshold int) ([]string, error) {
+	wrapKey := make([]byte, splitKeySize)
+	if _, err := rand.Read(wrapKey); err != nil {
+		return nil, err
+	}
+
+	parts, err := util.SplitSecret(wrapKey, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := a.decodeKeys(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	a.passphrase = nil
+	a.splitKey = wrapKey
+	a.splitThreshold = threshold
+
+	if err = a.rewriteKeys(paths, keys); err != nil {
+		return nil, err
+	}
+
+	encoded := make([]string, len(parts))
+	for i, part := range parts {
+		encoded[i] = util.EncodeB64(part)
+	}
+
+	return encoded, nil
+}
+
+// awaitShares listens on the unlock socket until enough shares have been
+// submitted to recover a key that opens the CA key
+func (a *Authority) awaitShares(threshold int, opens func([]byte) bool) ([]byte, error) {
+	if a.splitKey != nil {
+		return a.splitKey, nil
+	}
+
+	path := a.unlockSocket()
+	if err := checkFile(path, true); err != nil {
+		return nil, err
+	}
+
+	ln, err := util.ListenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	log.WithFields(log.Fields{
+		"ca":        a.Name,
+		"socket":    path,
+		"threshold": threshold,
+	}).Info("CA key is split, waiting for shares to be submitted with zcert ca unlock")
+
+	var shares [][]byte
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		key := acceptShare(conn, &shares, threshold, opens)
+		conn.Close()
+
+		if key != nil {
+			a.splitKey = key
+			a.splitThreshold = threshold
+			return key, nil
+		}
+	}
+}
+
+// acceptShare reads one share from conn and replies with the progress. It
+// returns the recovered key once threshold shares open the CA key.
+func acceptShare(conn net.Conn, shares *[][]byte, threshold int, opens func([]byte) bool) []byte {
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	reply := func(msg string) {
+		fmt.Fprintln(conn, msg)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return nil
+	}
+
+	share, err := util.DecodeB64(strings.TrimSpace(line))
+	if err != nil || len(share) != splitKeySize+1 {
+		reply("invalid share")
+		return nil
+	}
+
+	for _, s := range *shares {
+		if s[splitKeySize] == share[splitKeySize] {
+			reply("share already submitted")
+			return nil
+		}
+	}
+
+	*shares = append(*shares, share)
+	if len(*shares) < threshold {
+		log.WithFields(log.Fields{
+			"submitted": len(*shares),
+			"threshold": threshold,
+		}).Info("accepted key share")
+
+		reply(fmt.Sprintf("share accepted, %d more needed", threshold-len(*shares)))
+		return nil
+	}
+
+	key, err := util.CombineShares(*shares)
+	if err != nil || !opens(key) {
+		log.Warn("submitted key shares do not unlock the CA key, discarding them")
+
+		*shares = nil
+		reply("shares do not unlock the key, all shares have to be submitted again")
+		return nil
+	}
+
+	reply("unlocked")
+	return key
+}
+
+// SubmitShare sends a base64 encoded share to a server waiting on the unlock
+// socket and returns its reply
+func (a *Authority) SubmitShare(share string) (string, error) {
+	conn, err := net.Dial("unix", a.unlockSocket())
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err = fmt.Fprintln(conn, strings.TrimSpace(share)); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(reply), nil
+}
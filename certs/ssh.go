@@ -0,0 +1,474 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/db"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// kinds of OpenSSH certificate
+const (
+	SSHCertUser = "user"
+	SSHCertHost = "host"
+)
+
+// the SSH certificate authority's key, named like the signer backend names
+// keys, and its public key in authorized_keys format
+const (
+	sshKeyName = "ssh_ca.key"
+	sshPubName = "ssh_ca.pub"
+)
+
+// critical options and extensions OpenSSH understands in user certificates.
+// Host certificates can't have any.
+var sshCriticalOptions = map[string]bool{
+	"force-command":   true,
+	"source-address":  true,
+	"verify-required": true,
+}
+
+var sshExtensions = map[string]bool{
+	"no-touch-required":       true,
+	"permit-X11-forwarding":   true,
+	"permit-agent-forwarding": true,
+	"permit-port-forwarding":  true,
+	"permit-pty":              true,
+	"permit-user-rc":          true,
+}
+
+// defaultSSHExtensions are given to user certificates that don't ask for any,
+// like ssh-keygen does
+var defaultSSHExtensions = []string{
+	"permit-X11-forwarding",
+	"permit-agent-forwarding",
+	"permit-port-forwarding",
+	"permit-pty",
+	"permit-user-rc",
+}
+
+type SSHParams struct {
+	CertType   string
+	KeyID      string
+	Principals []string
+	Lifetime   time.Duration
+
+	CriticalOptions map[string]string
+	Extensions      map[string]string
+}
+
+type NoSSHAuthorityError struct{}
+
+type InvalidSSHKeyError struct {
+	err error
+}
+
+type SSHCertTypeError struct {
+	certType string
+}
+
+type NoPrincipalsError struct{}
+
+type SSHLifetimeError struct {
+	requested time.Duration
+	max       time.Duration
+}
+
+type SSHOptionError struct {
+	kind string
+	name string
+}
+
+type SSHPrincipalError struct {
+	certType  string
+	principal string
+}
+
+type SSHHostCertError struct{}
+
+func (e *NoSSHAuthorityError) Error() string {
+	return "no ssh certificate authority, create one with zcert ca ssh-init"
+}
+
+func (e *InvalidSSHKeyError) Error() string {
+	return fmt.Sprintf("invalid ssh public key: %s", e.err)
+}
+
+func (e *InvalidSSHKeyError) Unwrap() error {
+	return e.err
+}
+
+func (e *SSHCertTypeError) Error() string {
+	return fmt.Sprintf("unknown ssh certificate type %q, use user or host", e.certType)
+}
+
+func (e *NoPrincipalsError) Error() string {
+	return "ssh certificates need at least one principal"
+}
+
+func (e *SSHLifetimeError) Error() string {
+	if e.requested <= 0 {
+		return fmt.Sprintf("invalid ssh certificate lifetime %s", e.requested)
+	}
+
+	return fmt.Sprintf("ssh certificate lifetime %s is longer than the allowed %s", e.requested, e.max)
+}
+
+func (e *SSHOptionError) Error() string {
+	return fmt.Sprintf("%s %q is not allowed", e.kind, e.name)
+}
+
+func (e *SSHPrincipalError) Error() string {
+	return fmt.Sprintf("principal %q is not allowed in %s certificates", e.principal, e.certType)
+}
+
+func (e *SSHHostCertError) Error() string {
+	return "host certificates need the adminkey unless ssh.host_domains allows their principals"
+}
+
+func (e *InvalidSSHKeyError) PolicyViolation() {}
+func (e *SSHCertTypeError) PolicyViolation()   {}
+func (e *NoPrincipalsError) PolicyViolation()  {}
+func (e *SSHLifetimeError) PolicyViolation()   {}
+func (e *SSHOptionError) PolicyViolation()     {}
+func (e *SSHPrincipalError) PolicyViolation()  {}
+func (e *SSHHostCertError) PolicyViolation()   {}
+
+// ParseSSHPublicKey parses a public key in authorized_keys format, as found
+// in id_ed25519.pub
+func ParseSSHPublicKey(b []byte) (ssh.PublicKey, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, &InvalidSSHKeyError{err: err}
+	}
+
+	if _, ok := pub.(ssh.CryptoPublicKey); !ok {
+		return nil, &InvalidSSHKeyError{err: fmt.Errorf("%s keys can't be certified", pub.Type())}
+	}
+
+	return pub, nil
+}
+
+// CreateSSHCA creates the ed25519 key of the SSH certificate authority with
+// the signer backend, and writes its public key to ssh_ca.pub for
+// TrustedUserCAKeys and @cert-authority lines
+func (a *Authority) CreateSSHCA(force bool) error {
+	pubPath := a.storagePath(sshPubName)
+	if err := checkFile(pubPath, force); err != nil {
+		return err
+	}
+
+	if a.conf.GetString("ca.signer") == SignerFile {
+		if err := checkFile(a.storagePath(sshKeyName), force); err != nil {
+			return err
+		}
+	}
+
+	b, err := a.Backend()
+	if err != nil {
+		return err
+	}
+
+	key, err := b.GenerateKey(sshKeyName, KeyTypeEd25519)
+	if err != nil {
+		return err
+	}
+
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"fingerprint": ssh.FingerprintSHA256(pub),
+	}).Info("created ssh certificate authority")
+
+	return writeFile(pubPath, bytes.NewReader(ssh.MarshalAuthorizedKey(pub)), 0644)
+}
+
+// loadSSH loads the SSH certificate authority's key when ssh_ca.pub exists
+func (a *Authority) loadSSH() error {
+	a.SSHSigner = nil
+
+	pubBytes, err := os.ReadFile(a.storagePath(sshPubName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(pubBytes)
+	if err != nil {
+		return err
+	}
+
+	key, err := a.loadKey(sshKeyName)
+	if err != nil {
+		return err
+	}
+
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+		return &KeyMismatchError{name: sshKeyName}
+	}
+
+	a.SSHSigner = key
+	return nil
+}
+
+// SSHPublicKey returns the SSH certificate authority's public key in
+// authorized_keys format
+func (a *Authority) SSHPublicKey() ([]byte, error) {
+	if a.SSHSigner == nil {
+		return nil, &NoSSHAuthorityError{}
+	}
+
+	pub, err := ssh.NewPublicKey(a.SSHSigner.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.MarshalAuthorizedKey(pub), nil
+}
+
+// sshOptions checks requested critical options or extensions against the
+// ones OpenSSH understands
+func sshOptions(kind string, requested map[string]string, allowed map[string]bool) (map[string]string, error) {
+	options := map[string]string{}
+	for name, value := range requested {
+		if !allowed[name] {
+			return nil, &SSHOptionError{kind: kind, name: name}
+		}
+
+		options[name] = value
+	}
+
+	return options, nil
+}
+
+// checkPrincipals makes sure a certificate requested with the authkey only
+// names principals it may. User principals have to match a pattern in
+// ssh.allowed_principals, and host principals have to be within
+// ssh.host_domains, checked like dns name constraints. Admin requests may
+// name any principal.
+func (a *Authority) checkPrincipals(certType string, principals []string, admin bool) error {
+	if admin {
+		return nil
+	}
+
+	if certType == SSHCertHost {
+		domains := a.conf.GetStringSlice("ssh.host_domains")
+		if len(domains) == 0 {
+			return &SSHHostCertError{}
+		}
+
+		for _, principal := range principals {
+			if !permitted(principal, domains, nil, matchDomain) {
+				return &SSHPrincipalError{certType: certType, principal: principal}
+			}
+		}
+
+		return nil
+	}
+
+	patterns := a.conf.GetStringSlice("ssh.allowed_principals")
+	for _, principal := range principals {
+		allowed := false
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, principal); ok {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return &SSHPrincipalError{certType: SSHCertUser, principal: principal}
+		}
+	}
+
+	return nil
+}
+
+// sshTemplate builds the certificate described by params, checking it
+// against the configured limits
+func (a *Authority) sshTemplate(pub ssh.PublicKey, params SSHParams, admin bool) (*ssh.Certificate, error) {
+	crt := &ssh.Certificate{
+		Key:             pub,
+		KeyId:           params.KeyID,
+		ValidPrincipals: params.Principals,
+	}
+
+	if len(params.Principals) == 0 {
+		return nil, &NoPrincipalsError{}
+	}
+
+	if len(crt.KeyId) == 0 {
+		crt.KeyId = params.Principals[0]
+	}
+
+	lifetime := params.Lifetime
+	if lifetime == 0 {
+		lifetime = a.conf.GetDuration("ssh.lifetime")
+	}
+
+	if max := a.conf.GetDuration("ssh.max_lifetime"); lifetime <= 0 || lifetime > max {
+		return nil, &SSHLifetimeError{requested: lifetime, max: max}
+	}
+
+	if err := a.checkPrincipals(params.CertType, params.Principals, admin); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	crt.ValidAfter = uint64(now.Unix())
+	crt.ValidBefore = uint64(now.Add(lifetime).Unix())
+
+	var err error
+	switch params.CertType {
+	case "", SSHCertUser:
+		crt.CertType = ssh.UserCert
+
+		crt.CriticalOptions, err = sshOptions("critical option", params.CriticalOptions, sshCriticalOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		crt.Extensions, err = sshOptions("extension", params.Extensions, sshExtensions)
+		if err != nil {
+			return nil, err
+		}
+
+		if params.Extensions == nil {
+			for _, name := range defaultSSHExtensions {
+				crt.Extensions[name] = ""
+			}
+		}
+	case SSHCertHost:
+		crt.CertType = ssh.HostCert
+
+		crt.CriticalOptions, err = sshOptions("critical option", params.CriticalOptions, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		crt.Extensions, err = sshOptions("extension", params.Extensions, nil)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &SSHCertTypeError{certType: params.CertType}
+	}
+
+	return crt, nil
+}
+
+// SignSSH certifies pub with the SSH certificate authority and records the
+// certificate in the inventory. The certificate is returned in
+// authorized_keys format, as ssh expects to find it in id_ed25519-cert.pub.
+// admin is whether the request was made with the adminkey, which lifts the
+// principal policy.
+func (a *Authority) SignSSH(pub ssh.PublicKey, params SSHParams, admin bool) ([]byte, error) {
+	if a.SSHSigner == nil {
+		return nil, &NoSSHAuthorityError{}
+	}
+
+	if err := a.CheckKeyPolicy(pub.(ssh.CryptoPublicKey).CryptoPublicKey()); err != nil {
+		return nil, err
+	}
+
+	crt, err := a.sshTemplate(pub, params, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromSigner(a.SSHSigner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		crt.Serial, err = sshSerial(tx)
+		if err != nil {
+			return err
+		}
+
+		if err = crt.SignCert(rand.Reader, signer); err != nil {
+			return err
+		}
+
+		certType := SSHCertUser
+		if crt.CertType == ssh.HostCert {
+			certType = SSHCertHost
+		}
+
+		return tx.Create(&db.SSHCertificate{
+			Serial:     sshSerialString(crt.Serial),
+			KeyID:      crt.KeyId,
+			CertType:   certType,
+			Principals: crt.ValidPrincipals,
+
+			ValidAfter:  time.Unix(int64(crt.ValidAfter), 0),
+			ValidBefore: time.Unix(int64(crt.ValidBefore), 0),
+
+			CriticalOptions: crt.CriticalOptions,
+			Extensions:      crt.Extensions,
+
+			Fingerprint:   ssh.FingerprintSHA256(pub),
+			CAFingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"serial":     sshSerialString(crt.Serial),
+		"keyid":      crt.KeyId,
+		"principals": crt.ValidPrincipals,
+		"expires":    time.Unix(int64(crt.ValidBefore), 0),
+	}).Info("issued ssh certificate")
+
+	return ssh.MarshalAuthorizedKey(crt), nil
+}
+
+func sshSerialString(serial uint64) string {
+	return fmt.Sprintf("%x", serial)
+}
+
+// sshSerial picks a random serial that no recorded SSH certificate has
+func sshSerial(tx *gorm.DB) (uint64, error) {
+	buf := make([]byte, 8)
+	for i := 0; i < maxSerialAttempts; i++ {
+		if _, err := rand.Read(buf); err != nil {
+			return 0, err
+		}
+
+		serial := binary.BigEndian.Uint64(buf)
+		if serial == 0 {
+			continue
+		}
+
+		exists, err := db.SSHSerialExists(tx, sshSerialString(serial))
+		if err != nil {
+			return 0, err
+		}
+
+		if !exists {
+			return serial, nil
+		}
+	}
+
+	return 0, &SerialCollisionError{attempts: maxSerialAttempts}
+}
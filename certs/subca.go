@@ -0,0 +1,145 @@
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+)
+
+// CAParams describes a subordinate certificate authority. MaxPathLen is the
+// number of certificate authorities allowed below it, -1 for no limit.
+type CAParams struct {
+	Lifetime   time.Duration
+	MaxPathLen int
+
+	PermittedDNSDomains []string
+	ExcludedDNSDomains  []string
+	PermittedIPRanges   []string
+	ExcludedIPRanges    []string
+}
+
+type PathLenError struct {
+	requested int
+	allowed   int
+}
+
+type InvalidIPRangeError struct {
+	cidr string
+}
+
+type SubordinateLifetimeError struct {
+	lifetime time.Duration
+	notAfter time.Time
+}
+
+func (e *PathLenError) Error() string {
+	if e.allowed == 0 {
+		return "this certificate authority may not issue subordinate certificate authorities"
+	}
+
+	return fmt.Sprintf("path length %d is not allowed, subordinate certificate authorities must have a path length below %d", e.requested, e.allowed)
+}
+
+func (e *InvalidIPRangeError) Error() string {
+	return fmt.Sprintf("invalid ip range %q, ranges must be in CIDR notation", e.cidr)
+}
+
+func (e *SubordinateLifetimeError) Error() string {
+	if e.lifetime <= 0 {
+		return fmt.Sprintf("invalid lifetime %s, subordinate certificate authorities need a positive lifetime", e.lifetime)
+	}
+
+	return fmt.Sprintf("lifetime %s would outlive this certificate authority, which expires at %s", e.lifetime, e.notAfter.Format(time.RFC3339))
+}
+
+func (e *PathLenError) PolicyViolation()             {}
+func (e *InvalidIPRangeError) PolicyViolation()      {}
+func (e *SubordinateLifetimeError) PolicyViolation() {}
+
+func parseIPRanges(cidrs []string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, &InvalidIPRangeError{cidr: cidr}
+		}
+
+		ranges = append(ranges, ipnet)
+	}
+
+	return ranges, nil
+}
+
+// checkPathLen makes sure a subordinate with the requested path length fits
+// under the authority's own path length constraint
+func (a *Authority) checkPathLen(requested int) error {
+	if a.Cert.MaxPathLen < 0 {
+		return nil
+	}
+
+	if requested < 0 || requested >= a.Cert.MaxPathLen {
+		return &PathLenError{requested: requested, allowed: a.Cert.MaxPathLen}
+	}
+
+	return nil
+}
+
+// SignCACSR issues a subordinate certificate authority for the CSR,
+// restricted by the name constraints in params
+func (a *Authority) SignCACSR(csr *x509.CertificateRequest, params CAParams) ([]byte, error) {
+	if err := a.checkPathLen(params.MaxPathLen); err != nil {
+		return nil, err
+	}
+
+	// a subordinate can't be valid for longer than the certificate that
+	// vouches for it
+	now := time.Now()
+	notAfter := now.Add(params.Lifetime)
+	if params.Lifetime <= 0 || notAfter.After(a.Cert.NotAfter) {
+		return nil, &SubordinateLifetimeError{lifetime: params.Lifetime, notAfter: a.Cert.NotAfter}
+	}
+
+	permittedIPs, err := parseIPRanges(params.PermittedIPRanges)
+	if err != nil {
+		return nil, err
+	}
+
+	excludedIPs, err := parseIPRanges(params.ExcludedIPRanges)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := a.LoadProfile(DefaultProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	crt := &x509.Certificate{
+		Subject:               csr.Subject,
+		NotBefore:             now,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            params.MaxPathLen,
+		MaxPathLenZero:        params.MaxPathLen == 0,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+
+		PermittedDNSDomains: params.PermittedDNSDomains,
+		ExcludedDNSDomains:  params.ExcludedDNSDomains,
+		PermittedIPRanges:   permittedIPs,
+		ExcludedIPRanges:    excludedIPs,
+
+		// email and uri constraints can't be requested, the subordinate
+		// inherits this authority's
+		PermittedEmailAddresses: a.Cert.PermittedEmailAddresses,
+		ExcludedEmailAddresses:  a.Cert.ExcludedEmailAddresses,
+		PermittedURIDomains:     a.Cert.PermittedURIDomains,
+		ExcludedURIDomains:      a.Cert.ExcludedURIDomains,
+	}
+
+	crt.PermittedDNSDomainsCritical = hasNameConstraints(crt)
+
+	return a.issue(csr, crt, profile, CSRParams{Lifetime: params.Lifetime})
+}
@@ -0,0 +1,73 @@
+package certs
+
+import (
+	"crypto/x509/pkix"
+)
+
+// SubjectAttributes are the distinguished name attributes other than the
+// common name, as configured in zcert.yml
+type SubjectAttributes struct {
+	Country            []string `mapstructure:"country"`
+	Organization       []string `mapstructure:"organization"`
+	OrganizationalUnit []string `mapstructure:"organizational_unit"`
+	Locality           []string `mapstructure:"locality"`
+	Province           []string `mapstructure:"province"`
+	StreetAddress      []string `mapstructure:"street_address"`
+	PostalCode         []string `mapstructure:"postal_code"`
+	SerialNumber       string   `mapstructure:"serial_number"`
+}
+
+// SubjectPolicy controls the subject of certificates issued under a profile.
+// Force attributes replace whatever the CSR asked for, Default attributes are
+// only used when the CSR leaves that attribute empty.
+type SubjectPolicy struct {
+	Force   SubjectAttributes `mapstructure:"force"`
+	Default SubjectAttributes `mapstructure:"default"`
+}
+
+// Name returns a pkix.Name with these attributes and the given common name
+func (sa SubjectAttributes) Name(commonName string) pkix.Name {
+	return pkix.Name{
+		Country:            sa.Country,
+		Organization:       sa.Organization,
+		OrganizationalUnit: sa.OrganizationalUnit,
+		Locality:           sa.Locality,
+		Province:           sa.Province,
+		StreetAddress:      sa.StreetAddress,
+		PostalCode:         sa.PostalCode,
+		SerialNumber:       sa.SerialNumber,
+		CommonName:         commonName,
+	}
+}
+
+func applyAttribute(requested, force, def []string) []string {
+	if len(force) > 0 {
+		return force
+	}
+
+	if len(requested) == 0 {
+		return def
+	}
+
+	return requested
+}
+
+// Apply returns name with the policy's forced and default attributes applied.
+// The common name is always left alone.
+func (sp SubjectPolicy) Apply(name pkix.Name) pkix.Name {
+	name.Country = applyAttribute(name.Country, sp.Force.Country, sp.Default.Country)
+	name.Organization = applyAttribute(name.Organization, sp.Force.Organization, sp.Default.Organization)
+	name.OrganizationalUnit = applyAttribute(name.OrganizationalUnit, sp.Force.OrganizationalUnit, sp.Default.OrganizationalUnit)
+	name.Locality = applyAttribute(name.Locality, sp.Force.Locality, sp.Default.Locality)
+	name.Province = applyAttribute(name.Province, sp.Force.Province, sp.Default.Province)
+	name.StreetAddress = applyAttribute(name.StreetAddress, sp.Force.StreetAddress, sp.Default.StreetAddress)
+	name.PostalCode = applyAttribute(name.PostalCode, sp.Force.PostalCode, sp.Default.PostalCode)
+
+	if len(sp.Force.SerialNumber) > 0 {
+		name.SerialNumber = sp.Force.SerialNumber
+	} else if len(name.SerialNumber) == 0 {
+		name.SerialNumber = sp.Default.SerialNumber
+	}
+
+	return name
+}
@@ -0,0 +1,134 @@
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/stormentt/zcert/db"
+	"gorm.io/gorm"
+)
+
+// how many active certificates may exist for the same set of names
+const (
+	UniquenessUnlimited = "unlimited"
+	UniquenessReject    = "reject"
+	UniquenessSupersede = "supersede"
+)
+
+type SubjectInUseError struct {
+	names  string
+	serial string
+}
+
+type InvalidUniquenessError struct {
+	usage string
+	mode  string
+}
+
+func (e *SubjectInUseError) Error() string {
+	return fmt.Sprintf("certificate %s is still active for %s", e.serial, e.names)
+}
+
+func (e *SubjectInUseError) PolicyViolation() {}
+
+func (e *InvalidUniquenessError) Error() string {
+	return fmt.Sprintf("policy.uniqueness.%s has unknown value %q", e.usage, e.mode)
+}
+
+// NameSet returns a canonical representation of the certificate's common name
+// and subject alternative names, used to find certificates for the same
+// identity regardless of name order or case
+func NameSet(crt *x509.Certificate) string {
+	seen := map[string]bool{}
+	add := func(kind, name string) {
+		if len(name) > 0 {
+			seen[fmt.Sprintf("%s:%s", kind, name)] = true
+		}
+	}
+
+	add("cn", strings.ToLower(crt.Subject.CommonName))
+	for _, name := range crt.DNSNames {
+		add("dns", strings.ToLower(name))
+	}
+
+	for _, ip := range crt.IPAddresses {
+		add("ip", ip.String())
+	}
+
+	for _, email := range crt.EmailAddresses {
+		add("email", strings.ToLower(email))
+	}
+
+	for _, uri := range crt.URIs {
+		add("uri", uri.String())
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (a *Authority) uniquenessMode(usage string) (string, error) {
+	mode := a.conf.GetString(fmt.Sprintf("policy.uniqueness.%s", usage))
+	switch mode {
+	case "":
+		return UniquenessUnlimited, nil
+	case UniquenessUnlimited, UniquenessReject, UniquenessSupersede:
+		return mode, nil
+	default:
+		return "", &InvalidUniquenessError{usage: usage, mode: mode}
+	}
+}
+
+// checkUniqueness applies policy.uniqueness for every usage requested in
+// params. It returns the active certificates that the new certificate will
+// supersede.
+func (a *Authority) checkUniqueness(tx *gorm.DB, names string, params CSRParams) ([]db.SignedCertificate, error) {
+	usages := map[string]string{}
+	if params.ClientAuth {
+		usages["client"] = "client_auth"
+	}
+
+	if params.ServerAuth {
+		usages["server"] = "server_auth"
+	}
+
+	var supersede []db.SignedCertificate
+	for usage, column := range usages {
+		mode, err := a.uniquenessMode(usage)
+		if err != nil {
+			return nil, err
+		}
+
+		if mode == UniquenessUnlimited {
+			continue
+		}
+
+		var active []db.SignedCertificate
+		err = tx.Scopes(db.Active).
+			Where("name_set = ?", names).
+			Where(fmt.Sprintf("%s = ?", column), true).
+			Find(&active).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if len(active) == 0 {
+			continue
+		}
+
+		if mode == UniquenessReject {
+			return nil, &SubjectInUseError{names: names, serial: active[0].Serial}
+		}
+
+		supersede = append(supersede, active...)
+	}
+
+	return supersede, nil
+}
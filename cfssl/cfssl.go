@@ -0,0 +1,102 @@
+package cfssl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/certs"
+)
+
+// error categories, as cfssl numbers them
+const (
+	codeCertificate = 1000
+	codePolicy      = 5000
+	codeCSR         = 9000
+)
+
+// apiError is reported to the client the way cfssl reports errors: code is a
+// cfssl error category or, for malformed requests, the HTTP status
+type apiError struct {
+	status  int
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func newError(status, code int, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...interface{}) *apiError {
+	return newError(http.StatusBadRequest, http.StatusBadRequest, format, args...)
+}
+
+type responseMessage struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// response is the envelope every cfssl API response comes in
+type response struct {
+	Success  bool              `json:"success"`
+	Result   interface{}       `json:"result"`
+	Errors   []responseMessage `json:"errors"`
+	Messages []responseMessage `json:"messages"`
+}
+
+// Server serves a cfssl compatible API for a certificate authority, so cfssl
+// clients can use it as their remote
+type Server struct {
+	authority *certs.Authority
+}
+
+func New(a *certs.Authority) *Server {
+	return &Server{authority: a}
+}
+
+// Routes adds the cfssl API to r under prefix
+func (s *Server) Routes(r *gin.Engine, prefix string) {
+	group := r.Group(prefix)
+	group.POST("/sign", s.sign)
+	group.POST("/authsign", s.authsign)
+	group.POST("/info", s.info)
+	group.POST("/certinfo", s.certinfo)
+}
+
+func (s *Server) respond(c *gin.Context, result interface{}) {
+	c.JSON(http.StatusOK, response{
+		Success:  true,
+		Result:   result,
+		Errors:   []responseMessage{},
+		Messages: []responseMessage{},
+	})
+}
+
+// fail responds with a cfssl error. Policy violations are passed on to the
+// client, anything else that isn't an apiError is hidden.
+func (s *Server) fail(c *gin.Context, err error) {
+	var apiErr *apiError
+	var violation certs.PolicyViolation
+	if errors.As(err, &violation) {
+		apiErr = newError(http.StatusBadRequest, codePolicy, "%s", err)
+	} else if !errors.As(err, &apiErr) {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  c.Request.URL.Path,
+		}).Error("cfssl request failed")
+
+		apiErr = newError(http.StatusInternalServerError, http.StatusInternalServerError, "internal server error")
+	}
+
+	c.JSON(apiErr.status, response{
+		Success:  false,
+		Errors:   []responseMessage{{Code: apiErr.code, Message: apiErr.message}},
+		Messages: []responseMessage{},
+	})
+}
@@ -0,0 +1,219 @@
+package cfssl
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+type infoRequest struct {
+	Label   string `json:"label"`
+	Profile string `json:"profile"`
+}
+
+type certinfoRequest struct {
+	Certificate    string `json:"certificate"`
+	Domain         string `json:"domain"`
+	Serial         string `json:"serial"`
+	AuthorityKeyID string `json:"authority_key_id"`
+}
+
+// name is a distinguished name as cfssl's certinfo shows it
+type name struct {
+	CommonName         string `json:"common_name,omitempty"`
+	SerialNumber       string `json:"serial_number,omitempty"`
+	Country            string `json:"country,omitempty"`
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizational_unit,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	Province           string `json:"province,omitempty"`
+	StreetAddress      string `json:"street_address,omitempty"`
+	PostalCode         string `json:"postal_code,omitempty"`
+}
+
+type certificateInfo struct {
+	Subject            name      `json:"subject"`
+	Issuer             name      `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	SANs               []string  `json:"sans"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	SignatureAlgorithm string    `json:"sigalg,omitempty"`
+	AKI                string    `json:"authority_key_id"`
+	SKI                string    `json:"subject_key_id,omitempty"`
+	RawPEM             string    `json:"pem,omitempty"`
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func newName(n pkix.Name) name {
+	return name{
+		CommonName:         n.CommonName,
+		SerialNumber:       n.SerialNumber,
+		Country:            first(n.Country),
+		Organization:       first(n.Organization),
+		OrganizationalUnit: first(n.OrganizationalUnit),
+		Locality:           first(n.Locality),
+		Province:           first(n.Province),
+		StreetAddress:      first(n.StreetAddress),
+		PostalCode:         first(n.PostalCode),
+	}
+}
+
+// formatKeyID formats a key id the way cfssl does, as colon separated hex
+func formatKeyID(id []byte) string {
+	parts := make([]string, len(id))
+	for i, b := range id {
+		parts[i] = hex.EncodeToString([]byte{b})
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// parseKeyID accepts key ids with or without colons
+func parseKeyID(id string) ([]byte, error) {
+	return hex.DecodeString(strings.ReplaceAll(id, ":", ""))
+}
+
+// info describes the certificate authority and how a cfssl profile issues
+func (s *Server) info(c *gin.Context) {
+	var req infoRequest
+	body, err := readBody(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &req); err != nil {
+			s.fail(c, badRequest("invalid info request: %s", err))
+			return
+		}
+	}
+
+	if len(req.Label) > 0 && req.Label != s.authority.Name {
+		s.fail(c, badRequest("unknown label %s", req.Label))
+		return
+	}
+
+	p, _, err := s.loadProfile(req.Profile)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if err = util.EncodeX509Cert(buf, s.authority.Cert.Raw); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	s.respond(c, gin.H{
+		"certificate": buf.String(),
+		"usages":      p.Usages,
+		"expiry":      p.Lifetime.String(),
+	})
+}
+
+// certinfo describes a certificate, given either as PEM or by its serial
+// number and authority key id. Certificates looked up by serial come from
+// the inventory, which doesn't keep the certificate itself.
+func (s *Server) certinfo(c *gin.Context) {
+	body, err := readBody(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var req certinfoRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		s.fail(c, badRequest("invalid certinfo request: %s", err))
+		return
+	}
+
+	switch {
+	case len(req.Certificate) > 0:
+		crt, err := util.DecodeX509Cert(bytes.NewBufferString(req.Certificate))
+		if err != nil {
+			s.fail(c, newError(http.StatusBadRequest, codeCertificate, "invalid certificate: %s", err))
+			return
+		}
+
+		s.respond(c, certificateInfo{
+			Subject:            newName(crt.Subject),
+			Issuer:             newName(crt.Issuer),
+			SerialNumber:       crt.SerialNumber.String(),
+			SANs:               certs.SubjectAltNames(crt),
+			NotBefore:          crt.NotBefore,
+			NotAfter:           crt.NotAfter,
+			SignatureAlgorithm: crt.SignatureAlgorithm.String(),
+			AKI:                formatKeyID(crt.AuthorityKeyId),
+			SKI:                formatKeyID(crt.SubjectKeyId),
+			RawPEM:             req.Certificate,
+		})
+	case len(req.Serial) > 0:
+		s.certinfoBySerial(c, req)
+	case len(req.Domain) > 0:
+		s.fail(c, badRequest("looking up certificates by domain isn't supported"))
+	default:
+		s.fail(c, badRequest("certinfo needs a certificate or a serial"))
+	}
+}
+
+func (s *Server) certinfoBySerial(c *gin.Context, req certinfoRequest) {
+	serial, ok := new(big.Int).SetString(req.Serial, 10)
+	if !ok {
+		s.fail(c, badRequest("invalid serial %q", req.Serial))
+		return
+	}
+
+	record, err := db.CertBySerial(s.authority.DB, certs.SerialString(serial))
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if len(req.AuthorityKeyID) > 0 && record != nil {
+		aki, err := parseKeyID(req.AuthorityKeyID)
+		if err != nil {
+			s.fail(c, badRequest("invalid authority_key_id %q", req.AuthorityKeyID))
+			return
+		}
+
+		if hex.EncodeToString(aki) != record.AuthorityKeyID {
+			record = nil
+		}
+	}
+
+	if record == nil {
+		s.fail(c, newError(http.StatusNotFound, codeCertificate, "no certificate with serial %s", req.Serial))
+		return
+	}
+
+	aki, _ := hex.DecodeString(record.AuthorityKeyID)
+	s.respond(c, certificateInfo{
+		Subject:      newName(record.Subject),
+		Issuer:       newName(record.Issuer),
+		SerialNumber: serial.String(),
+		SANs:         record.SANs,
+		NotBefore:    record.NotBefore,
+		NotAfter:     record.NotAfter,
+		AKI:          formatKeyID(aki),
+	})
+}
@@ -0,0 +1,275 @@
+package cfssl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/auth"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/util"
+)
+
+// signRequest is cfssl's sign request. Only the fields zcert can honour are
+// used, and asking for the others is refused rather than ignored.
+type signRequest struct {
+	Hosts      []string          `json:"hosts"`
+	Request    string            `json:"certificate_request"`
+	Subject    json.RawMessage   `json:"subject"`
+	Profile    string            `json:"profile"`
+	Label      string            `json:"label"`
+	Serial     *big.Int          `json:"serial"`
+	Extensions []json.RawMessage `json:"extensions"`
+	NotBefore  time.Time         `json:"not_before"`
+	NotAfter   time.Time         `json:"not_after"`
+}
+
+// authRequest wraps a sign request with an HMAC-SHA256 token over it, as
+// cfssl's standard auth provider sends it
+type authRequest struct {
+	Token   []byte `json:"token"`
+	Request []byte `json:"request"`
+}
+
+// profile is how certificates asked for with a cfssl profile name are issued,
+// as configured in cfssl.profiles.<name>
+type profile struct {
+	Profile  string        `mapstructure:"profile"`
+	Lifetime time.Duration `mapstructure:"lifetime"`
+	Usages   []string      `mapstructure:"usages"`
+}
+
+type UnsupportedUsageError struct {
+	profile string
+	usage   string
+}
+
+func (e *UnsupportedUsageError) Error() string {
+	return fmt.Sprintf("cfssl profile %s has usage %q, which zcert can't issue", e.profile, e.usage)
+}
+
+// usages zcert derives from the certificate's key on its own, so they're
+// accepted and otherwise ignored
+var keyUsages = map[string]bool{
+	"signing":           true,
+	"digital signature": true,
+	"key encipherment":  true,
+}
+
+// loadProfile returns the cfssl profile called name. The default profile
+// always exists and issues from zcert's default profile for server and
+// client auth.
+func (s *Server) loadProfile(name string) (*profile, certs.CSRParams, error) {
+	conf := s.authority.Config()
+	if len(name) == 0 {
+		name = "default"
+	}
+
+	p := &profile{
+		Lifetime: conf.GetDuration("cfssl.lifetime"),
+	}
+
+	key := "cfssl.profiles." + name
+	if name != "default" && !conf.IsSet(key) {
+		return nil, certs.CSRParams{}, newError(http.StatusBadRequest, codePolicy, "unknown profile %s", name)
+	}
+
+	if err := conf.UnmarshalKey(key, p); err != nil {
+		return nil, certs.CSRParams{}, err
+	}
+
+	if p.Usages == nil {
+		p.Usages = []string{"signing", "key encipherment", "server auth", "client auth"}
+	}
+
+	params := certs.CSRParams{Lifetime: p.Lifetime, Profile: p.Profile}
+	for _, usage := range p.Usages {
+		switch {
+		case usage == "server auth":
+			params.ServerAuth = true
+		case usage == "client auth":
+			params.ClientAuth = true
+		case !keyUsages[usage]:
+			return nil, certs.CSRParams{}, &UnsupportedUsageError{profile: name, usage: usage}
+		}
+	}
+
+	return p, params, nil
+}
+
+// overrideHosts replaces the names a CSR asks for with hosts, sorting them
+// into ip addresses, email addresses, URIs and dns names like cfssl does
+func overrideHosts(csr *x509.CertificateRequest, hosts []string) {
+	csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs = nil, nil, nil, nil
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			csr.IPAddresses = append(csr.IPAddresses, ip)
+		} else if email, err := mail.ParseAddress(host); err == nil && email.Address == host {
+			csr.EmailAddresses = append(csr.EmailAddresses, host)
+		} else if uri, err := url.Parse(host); err == nil && len(uri.Scheme) > 0 && len(uri.Host) > 0 {
+			csr.URIs = append(csr.URIs, uri)
+		} else {
+			csr.DNSNames = append(csr.DNSNames, host)
+		}
+	}
+}
+
+// issue signs a cfssl sign request, returning the PEM encoded certificate
+func (s *Server) issue(body []byte) (string, error) {
+	var req signRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", badRequest("invalid sign request: %s", err)
+	}
+
+	if len(req.Label) > 0 && req.Label != s.authority.Name {
+		return "", badRequest("unknown label %s", req.Label)
+	}
+
+	if req.Serial != nil || len(req.Extensions) > 0 || !req.NotBefore.IsZero() || !req.NotAfter.IsZero() {
+		return "", badRequest("serial, extensions, not_before and not_after can't be requested")
+	}
+
+	if len(req.Subject) > 0 && string(req.Subject) != "null" {
+		return "", badRequest("subject overrides aren't supported, use a zcert profile's subject policy")
+	}
+
+	_, params, err := s.loadProfile(req.Profile)
+	if err != nil {
+		return "", err
+	}
+
+	csr, err := util.DecodeX509CSR(bytes.NewBufferString(req.Request))
+	if err != nil {
+		return "", newError(http.StatusBadRequest, codeCSR, "invalid certificate_request: %s", err)
+	}
+
+	if err = s.authority.ValidateCSR(csr); err != nil {
+		return "", err
+	}
+
+	if len(req.Hosts) > 0 {
+		overrideHosts(csr, req.Hosts)
+	}
+
+	signed, err := s.authority.SignCSR(csr, params)
+	if err != nil {
+		return "", err
+	}
+
+	// cfssl clients expect the certificate alone, without the issuer chain
+	block, _ := pem.Decode(signed)
+	if block == nil {
+		return "", &util.NoPEMDataError{}
+	}
+
+	log.WithFields(log.Fields{
+		"profile": req.Profile,
+		"hosts":   req.Hosts,
+	}).Info("signed certificate for cfssl client")
+
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+func readBody(c *gin.Context) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		return nil, badRequest("unable to read request")
+	}
+
+	return body, nil
+}
+
+// sign handles unwrapped sign requests, which have to be authenticated the
+// way zcert's own client does it, with a Content-HMAC header
+func (s *Server) sign(c *gin.Context) {
+	body, err := readBody(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	expectedHMAC, err := auth.GetHMACFromHeader(c)
+	if err != nil {
+		s.fail(c, newError(http.StatusUnauthorized, http.StatusUnauthorized, "sign requests need a Content-HMAC header, use authsign instead"))
+		return
+	}
+
+	match, err := auth.CheckHMACWithKey(s.authority.Config().GetString("authkey"), expectedHMAC, body)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if !match {
+		s.fail(c, newError(http.StatusUnauthorized, http.StatusUnauthorized, "Content-HMAC header does not match computed HMAC"))
+		return
+	}
+
+	crt, err := s.issue(body)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	s.respond(c, gin.H{"certificate": crt})
+}
+
+// authKey returns the key authsign tokens are made with: cfssl.auth_key,
+// hex encoded like in cfssl's own configuration, or the authkey
+func (s *Server) authKey() ([]byte, error) {
+	conf := s.authority.Config()
+	if key := conf.GetString("cfssl.auth_key"); len(key) > 0 {
+		return hex.DecodeString(key)
+	}
+
+	return []byte(conf.GetString("authkey")), nil
+}
+
+func (s *Server) authsign(c *gin.Context) {
+	body, err := readBody(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var req authRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		s.fail(c, badRequest("invalid authsign request: %s", err))
+		return
+	}
+
+	key, err := s.authKey()
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(req.Request)
+	if len(key) == 0 || !hmac.Equal(mac.Sum(nil), req.Token) {
+		s.fail(c, newError(http.StatusUnauthorized, http.StatusUnauthorized, "invalid token"))
+		return
+	}
+
+	crt, err := s.issue(req.Request)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	s.respond(c, gin.H{"certificate": crt})
+}
@@ -18,7 +18,40 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func SignCSR(w io.Writer, r io.Reader) error {
+// clientConfig returns the settings for talking to the named certificate
+// authority. Keys under cas.<ca> override the top level ones.
+func clientConfig(ca string) (*viper.Viper, error) {
+	conf := viper.New()
+	if err := conf.MergeConfigMap(viper.AllSettings()); err != nil {
+		return nil, err
+	}
+
+	if len(ca) > 0 {
+		if err := conf.MergeConfigMap(viper.GetStringMap("cas." + ca)); err != nil {
+			return nil, err
+		}
+	}
+
+	return conf, nil
+}
+
+// caPath returns path on the server for the named certificate authority
+func caPath(ca, path string) string {
+	if len(ca) == 0 {
+		return path
+	}
+
+	return "/" + ca + path
+}
+
+// SignCSR asks the server to sign a certificate signing request by the named
+// certificate authority, or the server's only one when ca is empty
+func SignCSR(w io.Writer, r io.Reader, ca, profile string) error {
+	conf, err := clientConfig(ca)
+	if err != nil {
+		return err
+	}
+
 	csr, err := util.DecodeX509CSR(r)
 	if err != nil {
 		return err
@@ -31,6 +64,7 @@ func SignCSR(w io.Writer, r io.Reader) error {
 			Lifetime:   time.Hour * 24 * 365,
 			ClientAuth: true,
 			ServerAuth: false,
+			Profile:    profile,
 		},
 		SecurityBlock: apitypes.SecurityBlock{
 			Nonce:       random.AlphaNum(32),
@@ -38,7 +72,43 @@ func SignCSR(w io.Writer, r io.Reader) error {
 		},
 	}
 
-	signed, err := sendCSR(scr)
+	signed, err := sendRequest(conf, caPath(ca, "/sign"), scr, conf.GetString("authkey"))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(signed)
+	return err
+}
+
+// SignCACSR asks the server to issue a subordinate certificate authority.
+// This is an admin request, authenticated with the adminkey.
+func SignCACSR(w io.Writer, r io.Reader, ca string, params certs.CAParams) error {
+	conf, err := clientConfig(ca)
+	if err != nil {
+		return err
+	}
+
+	adminkey, err := auth.AdminKey(conf)
+	if err != nil {
+		return err
+	}
+
+	csr, err := util.DecodeX509CSR(r)
+	if err != nil {
+		return err
+	}
+
+	req := apitypes.SignCACertReq{
+		CSR:    util.EncodeB64(csr.Raw),
+		Params: params,
+		SecurityBlock: apitypes.SecurityBlock{
+			Nonce:       random.AlphaNum(apitypes.NonceLength),
+			RequestTime: time.Now(),
+		},
+	}
+
+	signed, err := sendRequest(conf, caPath(ca, "/admin/sign-ca"), req, adminkey)
 	if err != nil {
 		return err
 	}
@@ -47,13 +117,15 @@ func SignCSR(w io.Writer, r io.Reader) error {
 	return err
 }
 
-func sendCSR(scr apitypes.SignCertReq) ([]byte, error) {
-	serverHost := viper.GetString("server")
-	url := fmt.Sprintf("%s/sign", serverHost)
+// sendRequest posts req to path on the server, authenticating the request and
+// checking the response with key
+func sendRequest(conf *viper.Viper, path string, req interface{}, key string) ([]byte, error) {
+	serverHost := conf.GetString("server")
+	url := fmt.Sprintf("%s%s", serverHost, path)
 
 	jsonbody := new(bytes.Buffer)
 	encoder := json.NewEncoder(jsonbody)
-	if err := encoder.Encode(scr); err != nil {
+	if err := encoder.Encode(req); err != nil {
 		return nil, err
 	}
 
@@ -62,7 +134,7 @@ func sendCSR(scr apitypes.SignCertReq) ([]byte, error) {
 		return nil, err
 	}
 
-	calcHMAC, err := auth.CalcHMAC(jsonbody.Bytes())
+	calcHMAC, err := auth.CalcHMACWithKey(key, jsonbody.Bytes())
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +175,7 @@ func sendCSR(scr apitypes.SignCertReq) ([]byte, error) {
 		return nil, err
 	}
 
-	match, err := auth.CheckHMAC(respExpHMAC, body)
+	match, err := auth.CheckHMACWithKey(key, respExpHMAC, body)
 	if err != nil {
 		return nil, err
 	}
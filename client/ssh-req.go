@@ -0,0 +1,56 @@
+package client
+
+import (
+	"io"
+	"time"
+
+	"github.com/stormentt/zcert/apitypes"
+	"github.com/stormentt/zcert/auth"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/util/random"
+)
+
+// SignSSH asks the server's SSH certificate authority to certify the public
+// key read from r, in authorized_keys format, and writes the certificate to w.
+// Admin requests are authenticated with the adminkey and may name any
+// principal.
+func SignSSH(w io.Writer, r io.Reader, ca string, params certs.SSHParams, admin bool) error {
+	conf, err := clientConfig(ca)
+	if err != nil {
+		return err
+	}
+
+	pub, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err = certs.ParseSSHPublicKey(pub); err != nil {
+		return err
+	}
+
+	req := apitypes.SignSSHReq{
+		PublicKey: string(pub),
+		Params:    params,
+		SecurityBlock: apitypes.SecurityBlock{
+			Nonce:       random.AlphaNum(apitypes.NonceLength),
+			RequestTime: time.Now(),
+		},
+	}
+
+	path, key := "/ssh/sign", conf.GetString("authkey")
+	if admin {
+		path = "/admin/ssh/sign"
+		if key, err = auth.AdminKey(conf); err != nil {
+			return err
+		}
+	}
+
+	signed, err := sendRequest(conf, caPath(ca, path), req, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(signed)
+	return err
+}
@@ -0,0 +1,62 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stormentt/zcert/certs"
+)
+
+// caCmd represents the ca command
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the certificate authority",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// newAuthority returns the certificate authority picked with --ca
+func newAuthority() *certs.Authority {
+	a, err := certs.New(caName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("unable to find certificate authority")
+	}
+
+	return a
+}
+
+// loadAuthority returns the certificate authority picked with --ca, with its
+// database opened and its certificates & keys loaded
+func loadAuthority() *certs.Authority {
+	a, err := certs.Load(caName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("unable to load CA")
+	}
+
+	return a
+}
+
+func init() {
+	rootCmd.AddCommand(caCmd)
+}
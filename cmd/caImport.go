@@ -0,0 +1,134 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"math/big"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var importCrtPath string
+var importKeyPath string
+var importSerialFile string
+var importLastSerial string
+var importForce bool
+var importEncrypt bool
+
+// highestImportedSerial returns the largest serial the imported CA issued,
+// from --last-serial or OpenSSL's serial file, which holds the next serial
+func highestImportedSerial() *big.Int {
+	hex := importLastSerial
+	next := false
+
+	if len(importSerialFile) > 0 {
+		b, err := os.ReadFile(importSerialFile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to read serial file")
+		}
+
+		hex = strings.TrimSpace(string(b))
+		next = true
+	}
+
+	if len(hex) == 0 {
+		return nil
+	}
+
+	serial, ok := new(big.Int).SetString(hex, 16)
+	if !ok {
+		log.WithFields(log.Fields{
+			"serial": hex,
+		}).Fatal("serial is not hex encoded")
+	}
+
+	if next {
+		serial.Sub(serial, big.NewInt(1))
+	}
+
+	return serial
+}
+
+// caImportCmd represents the ca import command
+var caImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Take over an existing certificate authority's certificate and key",
+	Long: `Take over an existing certificate authority's certificate and key.
+
+The certificate and key are checked to be a usable certificate authority and
+written to ca.crt & ca.key in storage.path. Any certificates after the first in
+--cert are the ones above it and are written to chain.crt.
+
+Give OpenSSL's serial file with --serial-file, or the highest serial the
+certificate authority issued with --last-serial, so that sequential serials
+continue above it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(importCrtPath) == 0 || len(importKeyPath) == 0 {
+			log.Fatal("--cert and --key are required")
+		}
+
+		highest := highestImportedSerial()
+
+		a := newAuthority()
+		if err := a.OpenDB(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to init DB")
+		}
+
+		if importEncrypt {
+			if err := a.EncryptKeys(); err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Fatal("could not read passphrase")
+			}
+		}
+
+		if _, err := a.Import(importCrtPath, importKeyPath, importForce); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to import certificate authority")
+		}
+
+		if highest == nil {
+			log.Warn("no serial given, sequential serials may collide with ones the certificate authority already issued")
+			return
+		}
+
+		if err := a.SeedSerial(highest); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to seed serial numbers")
+		}
+	},
+}
+
+func init() {
+	caCmd.AddCommand(caImportCmd)
+
+	caImportCmd.Flags().StringVar(&importCrtPath, "cert", "", "PEM encoded certificate of the certificate authority, optionally followed by its chain")
+	caImportCmd.Flags().StringVar(&importKeyPath, "key", "", "PEM encoded PKCS#8 private key of the certificate authority")
+	caImportCmd.Flags().StringVar(&importSerialFile, "serial-file", "", "OpenSSL serial file holding the next serial in hex")
+	caImportCmd.Flags().StringVar(&importLastSerial, "last-serial", "", "highest serial the certificate authority issued, in hex")
+	caImportCmd.Flags().BoolVarP(&importForce, "force", "f", false, "overwrite an existing certificate authority")
+	caImportCmd.Flags().BoolVar(&importEncrypt, "encrypt", false, "encrypt the imported key with a new passphrase")
+}
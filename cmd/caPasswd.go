@@ -0,0 +1,67 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var passwdKeyPath string
+var newPassphraseFD int
+var decryptKey bool
+
+// caPasswdCmd represents the ca passwd command
+var caPasswdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Change the passphrase of the certificate authority's keys",
+	Long: `Change the passphrase of the certificate authority's keys.
+
+ca.key and the keys of retired certificate authorities are re-encrypted with a
+new passphrase, or only the key in --key when given. The current passphrase is
+read like it is for "zcert server", and the new one from --new-passphrase-fd or
+the terminal. An unencrypted key is encrypted, and --decrypt removes the
+encryption instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		a := newAuthority()
+
+		paths := []string{passwdKeyPath}
+		if len(passwdKeyPath) == 0 {
+			var err error
+			paths, err = a.KeyPaths()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Fatal("unable to find keys")
+			}
+		}
+
+		if err := a.ChangePassphrase(paths, newPassphraseFD, decryptKey); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to change passphrase")
+		}
+	},
+}
+
+func init() {
+	caCmd.AddCommand(caPasswdCmd)
+
+	caPasswdCmd.Flags().StringVar(&passwdKeyPath, "key", "", "only change the passphrase of this key, e.g. an offline root.key")
+	caPasswdCmd.Flags().IntVar(&newPassphraseFD, "new-passphrase-fd", -1, "file descriptor to read the new passphrase from")
+	caPasswdCmd.Flags().BoolVar(&decryptKey, "decrypt", false, "store the keys unencrypted")
+}
@@ -0,0 +1,51 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var renewLifetime time.Duration
+
+// caRenewCmd represents the ca renew command
+var caRenewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "Re-issue the certificate authority's certificate with the same key",
+	Long: `Re-issue the self-signed certificate authority's certificate with a new
+lifetime, keeping its key, subject and subject key id.
+
+Certificates it already issued keep chaining to the renewed certificate, so
+nothing has to be re-issued. Distribute the new ca.crt to anything that trusts
+the old one and restart the server to serve it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := loadAuthority().Renew(renewLifetime); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to renew certificate authority")
+		}
+	},
+}
+
+func init() {
+	caCmd.AddCommand(caRenewCmd)
+
+	caRenewCmd.Flags().DurationVarP(&renewLifetime, "lifetime", "l", time.Hour*24*365*1, "renewed cert authority lifetime")
+}
@@ -0,0 +1,51 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var rolloverLifetime time.Duration
+
+// caRolloverCmd represents the ca rollover command
+var caRolloverCmd = &cobra.Command{
+	Use:   "rollover",
+	Short: "Replace the certificate authority with a new key, cross-signed with the old one",
+	Long: `Replace the certificate authority with a new key and certificate.
+
+The old and new certificate authorities are cross-signed. The old one is kept in
+retired/ under storage.path, where its key keeps signing CRLs for the
+certificates it issued, and /ca serves both until those certificates expire.
+Restart the server afterwards to start issuing from the new certificate authority.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := loadAuthority().Rollover(rolloverLifetime); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to roll over certificate authority")
+		}
+	},
+}
+
+func init() {
+	caCmd.AddCommand(caRolloverCmd)
+
+	caRolloverCmd.Flags().DurationVarP(&rolloverLifetime, "lifetime", "l", time.Hour*24*365*1, "new cert authority lifetime")
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var sshInitForce bool
+
+// caSSHInitCmd represents the ca ssh-init command
+var caSSHInitCmd = &cobra.Command{
+	Use:   "ssh-init",
+	Short: "Create the ed25519 key of the SSH certificate authority",
+	Long: `Create the ed25519 key of the SSH certificate authority.
+
+The key is kept by ca.signer like the certificate authority's own key, as
+ssh_ca.key, and its public key is written to ssh_ca.pub in storage.path. Add
+that to sshd's TrustedUserCAKeys to accept user certificates, or to
+known_hosts in a @cert-authority line to accept host certificates. Restart the
+server to start signing SSH certificates.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := loadAuthority().CreateSSHCA(sshInitForce); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to create ssh certificate authority")
+		}
+	},
+}
+
+func init() {
+	caCmd.AddCommand(caSSHInitCmd)
+
+	caSSHInitCmd.Flags().BoolVarP(&sshInitForce, "force", "f", false, "overwrite an existing ssh certificate authority")
+}
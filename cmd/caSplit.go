@@ -0,0 +1,68 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var splitShares int
+var splitThreshold int
+
+// caSplitCmd represents the ca split command
+var caSplitCmd = &cobra.Command{
+	Use:   "split",
+	Short: "Seal the certificate authority's keys under a key split into shares",
+	Long: `Seal the certificate authority's keys under a random key split into shares.
+
+ca.key and the keys of retired certificate authorities are sealed under a new
+random key, which is split with Shamir's secret sharing. The shares are printed
+one per line and are not stored anywhere, so hand each one to a different
+operator. From then on "zcert server" waits at startup until --threshold of them
+have been submitted with "zcert ca unlock".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		a := newAuthority()
+
+		paths, err := a.KeyPaths()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to find keys")
+		}
+
+		shares, err := a.Split(paths, splitShares, splitThreshold)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to split keys")
+		}
+
+		for _, share := range shares {
+			fmt.Println(share)
+		}
+	},
+}
+
+func init() {
+	caCmd.AddCommand(caSplitCmd)
+
+	caSplitCmd.Flags().IntVar(&splitShares, "shares", 5, "how many shares to create")
+	caSplitCmd.Flags().IntVar(&splitThreshold, "threshold", 3, "how many shares are needed to unlock the keys")
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// caUnlockCmd represents the ca unlock command
+var caUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Submit a key share to a server waiting to unlock its keys",
+	Long: `Submit a key share to a server waiting to unlock its keys.
+
+The share is read from the terminal, or from stdin when it isn't one, and sent
+over the unix socket in ca.unlock_socket (unlock.sock in storage.path by
+default), so this has to run on the server's machine.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var share string
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprint(os.Stderr, "key share: ")
+			b, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Fatal("unable to read share")
+			}
+
+			share = string(b)
+		} else {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && len(line) == 0 {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Fatal("unable to read share")
+			}
+
+			share = line
+		}
+
+		reply, err := newAuthority().SubmitShare(share)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to submit share")
+		}
+
+		fmt.Println(reply)
+	},
+}
+
+func init() {
+	caCmd.AddCommand(caUnlockCmd)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stormentt/zcert/certs"
+)
+
+var snapshotOutPath string
+var snapshotFormat string
+var snapshotForce bool
+
+// snapshotCmd represents the certs snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Export a signed snapshot of every issued certificate",
+	Long: `Export a signed snapshot of every issued certificate for auditors.
+
+Every certificate in the inventory is written to --out as json or csv, and a
+detached signature is written next to it with .sig appended. The signature is
+made with the certificate authority's key and covers the snapshot's sha256,
+when it was taken, how many certificates it holds and the certificate
+authority's certificate, which is included. Check it offline with
+"zcert certs snapshot verify".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if snapshotFormat != certs.SnapshotJSON && snapshotFormat != certs.SnapshotCSV {
+			log.Fatal("--format has to be json or csv")
+		}
+
+		if len(snapshotOutPath) == 0 {
+			snapshotOutPath = "snapshot." + snapshotFormat
+		}
+
+		sigPath := snapshotOutPath + ".sig"
+		for _, path := range []string{snapshotOutPath, sigPath} {
+			if _, err := os.Stat(path); err == nil && !snapshotForce {
+				log.WithFields(log.Fields{
+					"path": path,
+				}).Fatal("output already exists, will not procede without --force")
+			}
+		}
+
+		a := loadAuthority()
+
+		buf := new(bytes.Buffer)
+		manifest, err := a.Snapshot(buf, snapshotFormat)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to take snapshot")
+		}
+
+		manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to encode snapshot signature")
+		}
+
+		if err = os.WriteFile(snapshotOutPath, buf.Bytes(), 0644); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  snapshotOutPath,
+			}).Fatal("unable to write snapshot")
+		}
+
+		if err = os.WriteFile(sigPath, append(manifestBytes, '\n'), 0644); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  sigPath,
+			}).Fatal("unable to write snapshot signature")
+		}
+
+		log.WithFields(log.Fields{
+			"path":      snapshotOutPath,
+			"signature": sigPath,
+			"rows":      manifest.Rows,
+		}).Info("wrote signed snapshot")
+	},
+}
+
+func init() {
+	certsCmd.AddCommand(snapshotCmd)
+
+	snapshotCmd.Flags().StringVarP(&snapshotOutPath, "out", "o", "", "path to write the snapshot to (default is snapshot.<format>)")
+	snapshotCmd.Flags().StringVar(&snapshotFormat, "format", certs.SnapshotJSON, "json or csv")
+	snapshotCmd.Flags().BoolVarP(&snapshotForce, "force", "f", false, "overwrite the snapshot if it exists")
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/util"
+)
+
+var verifyCACertPath string
+var verifyPreviousPath string
+
+// verifySnapshot checks the snapshot at path against the signature next to
+// it, and returns its rows and manifest
+func verifySnapshot(path string, trusted []*x509.Certificate) ([]certs.SnapshotRow, *certs.SnapshotManifest) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path,
+		}).Fatal("unable to read snapshot")
+	}
+
+	manifestBytes, err := os.ReadFile(path + ".sig")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path + ".sig",
+		}).Fatal("unable to read snapshot signature")
+	}
+
+	manifest := &certs.SnapshotManifest{}
+	if err = json.Unmarshal(manifestBytes, manifest); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path + ".sig",
+		}).Fatal("unable to parse snapshot signature")
+	}
+
+	rows, err := certs.VerifySnapshot(data, manifest, trusted)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path,
+		}).Fatal("snapshot failed verification")
+	}
+
+	log.WithFields(log.Fields{
+		"path":      path,
+		"rows":      manifest.Rows,
+		"createdAt": manifest.CreatedAt,
+	}).Info("snapshot verified")
+
+	return rows, manifest
+}
+
+// snapshotVerifyCmd represents the certs snapshot verify command
+var snapshotVerifyCmd = &cobra.Command{
+	Use:   "verify <snapshot>",
+	Short: "Check a snapshot's signature and compare it with an earlier one",
+	Long: `Check a snapshot's signature and compare it with an earlier one.
+
+The snapshot is checked against the signature next to it, which has to be made
+by a certificate authority in --ca-cert, or by the configured certificate
+authority or one it replaced when --ca-cert isn't given. The certificate
+included in the signature is never trusted on its own. With --previous an
+earlier snapshot is verified too, it has to be signed by the same key, and
+every change between them other than new and newly revoked certificates is
+reported. The command fails if anything doesn't check out.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var trusted []*x509.Certificate
+		var err error
+		if len(verifyCACertPath) > 0 {
+			trusted, err = util.DecodeX509CertsFromPath(verifyCACertPath)
+		} else {
+			trusted, err = newAuthority().SnapshotSigners()
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to read the trusted certificate authority, give it with --ca-cert")
+		}
+
+		rows, manifest := verifySnapshot(args[0], trusted)
+		if len(verifyPreviousPath) == 0 {
+			return
+		}
+
+		previousRows, previous := verifySnapshot(verifyPreviousPath, trusted)
+
+		if err = certs.CheckSnapshotSigners(previous, manifest); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to compare snapshots")
+		}
+
+		diff, err := certs.CompareSnapshots(previousRows, rows, previous.CreatedAt, manifest.CreatedAt)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to compare snapshots")
+		}
+
+		for _, change := range diff.Unexpected {
+			log.WithFields(log.Fields{
+				"change": change,
+			}).Error("unexpected change since the previous snapshot")
+		}
+
+		log.WithFields(log.Fields{
+			"added":      len(diff.Added),
+			"revoked":    len(diff.Revoked),
+			"unexpected": len(diff.Unexpected),
+		}).Info("compared with the previous snapshot")
+
+		if len(diff.Unexpected) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotVerifyCmd)
+
+	snapshotVerifyCmd.Flags().StringVar(&verifyCACertPath, "ca-cert", "", "certificates of the certificate authorities the snapshots may be signed by (default is the configured certificate authority)")
+	snapshotVerifyCmd.Flags().StringVar(&verifyPreviousPath, "previous", "", "earlier snapshot to compare with")
+}
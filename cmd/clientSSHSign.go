@@ -0,0 +1,92 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/client"
+)
+
+var sshParams certs.SSHParams
+var sshOutPath string
+var sshExtensions []string
+var sshAdmin bool
+
+// sshSignCmd represents the ssh-sign command
+var sshSignCmd = &cobra.Command{
+	Use:   "ssh-sign",
+	Short: "Ask the server's SSH certificate authority to certify an SSH public key",
+	Long: `Ask the server's SSH certificate authority to certify an SSH public key.
+
+The certificate is written next to the public key, e.g. id_ed25519-cert.pub for
+id_ed25519.pub, where ssh picks it up automatically. User certificates get the
+usual permit-* extensions unless --extension is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		outPath = sshOutPath
+		if len(outPath) == 0 {
+			outPath = "-"
+			if inPath != "-" {
+				outPath = strings.TrimSuffix(inPath, ".pub") + "-cert.pub"
+			}
+		}
+
+		if cmd.Flags().Changed("extension") {
+			sshParams.Extensions = map[string]string{}
+			for _, ext := range sshExtensions {
+				name, value := ext, ""
+				if i := strings.Index(ext, "="); i >= 0 {
+					name, value = ext[:i], ext[i+1:]
+				}
+
+				sshParams.Extensions[name] = value
+			}
+		}
+
+		in, out := openInOut()
+
+		if err := client.SignSSH(out, in, caName, sshParams, sshAdmin); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := out.Close(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  outPath,
+			}).Fatal("unable to close output. output may be corrupted")
+		}
+	},
+}
+
+func init() {
+	clientCmd.AddCommand(sshSignCmd)
+
+	sshSignCmd.Flags().StringVarP(&inPath, "in", "i", "-", "path to the SSH public key, e.g. ~/.ssh/id_ed25519.pub")
+	sshSignCmd.Flags().StringVarP(&sshOutPath, "out", "o", "", "path to store the certificate (default is <key>-cert.pub next to the public key)")
+	sshSignCmd.Flags().BoolVarP(&force, "force", "f", false, "overwrite the certificate file if it exists")
+
+	sshSignCmd.Flags().StringVarP(&sshParams.CertType, "type", "t", certs.SSHCertUser, "certificate type, user or host")
+	sshSignCmd.Flags().StringVar(&sshParams.KeyID, "key-id", "", "key id logged by sshd (default is the first principal)")
+	sshSignCmd.Flags().StringSliceVarP(&sshParams.Principals, "principal", "n", nil, "user or host names the certificate is valid for")
+	sshSignCmd.Flags().DurationVarP(&sshParams.Lifetime, "lifetime", "l", 0, "certificate lifetime (default is the server's ssh.lifetime)")
+	sshSignCmd.Flags().StringToStringVar(&sshParams.CriticalOptions, "option", nil, "critical option as name=value, e.g. force-command=/bin/true")
+	sshSignCmd.Flags().StringSliceVar(&sshExtensions, "extension", nil, "extension to grant, e.g. permit-pty")
+	sshSignCmd.Flags().BoolVar(&sshAdmin, "admin", false, "sign with the adminkey, which may name any principal")
+}
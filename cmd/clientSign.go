@@ -28,6 +28,7 @@ import (
 var inPath string
 var outPath string
 var force bool
+var profile string
 
 // signCmd represents the sign command
 var signCmd = &cobra.Command{
@@ -35,45 +36,9 @@ var signCmd = &cobra.Command{
 	Short: "Ask the server to sign a certificate signing request",
 	Long:  ``,
 	Run: func(cmd *cobra.Command, args []string) {
-		var in io.Reader
-		var out io.WriteCloser
-		if inPath == "-" {
-			in = os.Stdin
-		} else {
-			inFile, err := os.Open(inPath)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error": err,
-					"path":  inPath,
-				}).Fatal("unable to open input")
-			}
-
-			in = inFile
-		}
-
-		if outPath == "-" {
-			out = os.Stdout
-		} else {
-			if _, err := os.Stat(outPath); err == nil {
-				if !force {
-					log.WithFields(log.Fields{
-						"path": outPath,
-					}).Fatal("output already exists, will not procede without --force")
-				}
-			}
-
-			outFile, err := os.OpenFile(outPath, os.O_RDWR|os.O_CREATE, 0644)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"path":  outPath,
-					"error": err,
-				}).Fatal("unable to create output")
-			}
-
-			out = outFile
-		}
+		in, out := openInOut()
 
-		if err := client.SignCSR(out, in); err != nil {
+		if err := client.SignCSR(out, in, caName, profile); err != nil {
 			log.Fatal(err)
 		}
 
@@ -86,10 +51,54 @@ var signCmd = &cobra.Command{
 	},
 }
 
+// openInOut opens the --in and --out paths, with - meaning stdin & stdout
+func openInOut() (io.Reader, io.WriteCloser) {
+	var in io.Reader
+	var out io.WriteCloser
+	if inPath == "-" {
+		in = os.Stdin
+	} else {
+		inFile, err := os.Open(inPath)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  inPath,
+			}).Fatal("unable to open input")
+		}
+
+		in = inFile
+	}
+
+	if outPath == "-" {
+		out = os.Stdout
+	} else {
+		if _, err := os.Stat(outPath); err == nil {
+			if !force {
+				log.WithFields(log.Fields{
+					"path": outPath,
+				}).Fatal("output already exists, will not procede without --force")
+			}
+		}
+
+		outFile, err := os.OpenFile(outPath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  outPath,
+				"error": err,
+			}).Fatal("unable to create output")
+		}
+
+		out = outFile
+	}
+
+	return in, out
+}
+
 func init() {
 	clientCmd.AddCommand(signCmd)
 
 	signCmd.Flags().StringVarP(&inPath, "in", "i", "-", "path to the certificate signing request")
 	signCmd.Flags().StringVarP(&outPath, "out", "o", "-", "path to store the signed certificate")
 	signCmd.Flags().BoolVarP(&force, "force", "f", false, "overwrite signed certificate file if it exists")
+	signCmd.Flags().StringVarP(&profile, "profile", "p", "", "issuance profile to request (default is the server's default profile)")
 }
@@ -0,0 +1,64 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/client"
+)
+
+var caParams certs.CAParams
+
+// signCACmd represents the sign-ca command
+var signCACmd = &cobra.Command{
+	Use:   "sign-ca",
+	Short: "Ask the server to sign a subordinate certificate authority (requires the adminkey)",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		in, out := openInOut()
+
+		if err := client.SignCACSR(out, in, caName, caParams); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := out.Close(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  outPath,
+			}).Fatal("unable to close output. output may be corrupted")
+		}
+	},
+}
+
+func init() {
+	clientCmd.AddCommand(signCACmd)
+
+	signCACmd.Flags().StringVarP(&inPath, "in", "i", "-", "path to the certificate signing request")
+	signCACmd.Flags().StringVarP(&outPath, "out", "o", "-", "path to store the signed certificate")
+	signCACmd.Flags().BoolVarP(&force, "force", "f", false, "overwrite signed certificate file if it exists")
+
+	signCACmd.Flags().DurationVarP(&caParams.Lifetime, "lifetime", "l", time.Hour*24*365, "subordinate certificate authority lifetime")
+	signCACmd.Flags().IntVar(&caParams.MaxPathLen, "path-len", 0, "how many certificate authorities may be below the subordinate, -1 for no limit")
+	signCACmd.Flags().StringSliceVar(&caParams.PermittedDNSDomains, "permit-dns", nil, "DNS domains the subordinate may issue for")
+	signCACmd.Flags().StringSliceVar(&caParams.ExcludedDNSDomains, "exclude-dns", nil, "DNS domains the subordinate may not issue for")
+	signCACmd.Flags().StringSliceVar(&caParams.PermittedIPRanges, "permit-ip", nil, "IP ranges (CIDR) the subordinate may issue for")
+	signCACmd.Flags().StringSliceVar(&caParams.ExcludedIPRanges, "exclude-ip", nil, "IP ranges (CIDR) the subordinate may not issue for")
+}
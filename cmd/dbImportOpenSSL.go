@@ -0,0 +1,89 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stormentt/zcert/util"
+)
+
+var opensslIndexPath string
+var opensslCertsDir string
+var opensslIssuerPath string
+
+// dbImportOpenSSLCmd represents the db import-openssl command
+var dbImportOpenSSLCmd = &cobra.Command{
+	Use:   "import-openssl",
+	Short: "Import the certificates issued by an OpenSSL ca into the inventory",
+	Long: `Import the certificates issued by an OpenSSL ca into the inventory.
+
+Every entry of OpenSSL's index.txt is recorded along with its revocation, using
+the certificate stored in --certs (OpenSSL's new_certs_dir) when it's there.
+Revoked certificates then appear on zcert's CRLs. Entries without a certificate
+are attributed to the certificate authority in --issuer, ca.crt in storage.path
+by default. Certificates already in the inventory are skipped, and so are
+certificates whose serial doesn't match their entry or that --issuer didn't sign.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(opensslIndexPath) == 0 || len(opensslCertsDir) == 0 {
+			log.Fatal("--index and --certs are required")
+		}
+
+		a := newAuthority()
+		if len(opensslIssuerPath) == 0 {
+			opensslIssuerPath = fmt.Sprintf("%s/%s", a.Config().GetString("storage.path"), "ca.crt")
+		}
+
+		issuer, err := util.DecodeX509CertFromPath(opensslIssuerPath)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to read issuer certificate")
+		}
+
+		if err = a.OpenDB(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to init DB")
+		}
+
+		stats, err := a.ImportOpenSSL(opensslIndexPath, opensslCertsDir, issuer)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to import OpenSSL database")
+		}
+
+		log.WithFields(log.Fields{
+			"imported":   stats.Imported,
+			"revoked":    stats.Revoked,
+			"existing":   stats.Existing,
+			"withoutPEM": stats.WithoutPEM,
+			"mismatched": stats.Mismatched,
+		}).Info("imported OpenSSL database")
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbImportOpenSSLCmd)
+
+	dbImportOpenSSLCmd.Flags().StringVar(&opensslIndexPath, "index", "", "OpenSSL's index.txt")
+	dbImportOpenSSLCmd.Flags().StringVar(&opensslCertsDir, "certs", "", "directory of issued certificates, OpenSSL's new_certs_dir")
+	dbImportOpenSSLCmd.Flags().StringVar(&opensslIssuerPath, "issuer", "", "certificate authority to attribute entries without a certificate to (default is ca.crt in storage.path)")
+}
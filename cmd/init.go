@@ -17,32 +17,83 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/stormentt/zcert/certs"
-	"github.com/stormentt/zcert/db"
 )
 
+var rootOnly bool
+var intermediate bool
+var rootCrtPath string
+var rootKeyPath string
+var pathLen int
+var encryptKey bool
+
 // initCmd represents the init command
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the database and the certificate authority",
-	Long:  ``,
+	Long: `Initialize the database and the certificate authority.
+
+By default a self-signed certificate authority is created and used directly by
+the server. To keep the root offline, run "zcert init --root-only" on an offline
+machine, then "zcert init --intermediate" there to create an intermediate signed
+by that root. Copy ca.crt, ca.key and chain.crt to the server's storage.path.
+
+With --encrypt the new key is encrypted with a passphrase, read from
+--passphrase-fd, the variable named by ca.passphrase_env, or the terminal.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(viper.GetString("ca.name")) == 0 {
+		a := newAuthority()
+		if len(a.Config().GetString("ca.name")) == 0 {
 			log.Fatal("ca.name is empty! make sure you configure that")
 		}
 
-		if err := db.InitDB(); err != nil {
+		if rootOnly && intermediate {
+			log.Fatal("--root-only and --intermediate can't be used together")
+		}
+
+		if encryptKey {
+			if err := a.EncryptKeys(); err != nil {
+				log.WithFields(log.Fields{
+					"Error": err,
+				}).Fatal("could not read passphrase")
+			}
+		}
+
+		if rootOnly {
+			if err := a.CreateRoot(); err != nil {
+				log.WithFields(log.Fields{
+					"Error": err,
+				}).Fatal("could not create root certificate authority")
+			}
+
+			return
+		}
+
+		if err := a.OpenDB(); err != nil {
 			log.WithFields(log.Fields{
 				"Error": err,
 			}).Fatal("could not init db")
 		}
 
-		if err := certs.CreateCA(); err != nil {
+		if intermediate {
+			if len(rootCrtPath) == 0 {
+				rootCrtPath = fmt.Sprintf("%s/%s", a.Config().GetString("storage.path"), "root.crt")
+			}
+
+			if err := a.CreateIntermediate(rootCrtPath, rootKeyPath, pathLen); err != nil {
+				log.WithFields(log.Fields{
+					"Error": err,
+				}).Fatal("could not create intermediate certificate authority")
+			}
+
+			return
+		}
+
+		if err := a.CreateCA(); err != nil {
 			log.WithFields(log.Fields{
 				"Error": err,
 			}).Fatal("could not create certificate authority")
@@ -55,10 +106,17 @@ func init() {
 
 	initCmd.Flags().BoolP("force", "f", false, "force initialization, overwriting any existing files")
 	initCmd.Flags().DurationP("lifetime", "l", time.Hour*24*365*1, "cert authority lifetime")
+	initCmd.Flags().BoolVar(&rootOnly, "root-only", false, "only create an offline root certificate authority in root.crt & root.key")
+	initCmd.Flags().BoolVar(&intermediate, "intermediate", false, "create an intermediate certificate authority signed by the root")
+	initCmd.Flags().StringVar(&rootCrtPath, "root-cert", "", "path to the root certificate (default is root.crt in storage.path)")
+	initCmd.Flags().StringVar(&rootKeyPath, "root-key", "", "path to the root private key (default is root.key from the signer backend)")
+	initCmd.Flags().IntVar(&pathLen, "path-len", 0, "how many certificate authorities may be below the intermediate")
+	initCmd.Flags().BoolVar(&encryptKey, "encrypt", false, "encrypt the new private key with a passphrase")
 
 	viper.BindPFlag("force", initCmd.Flags().Lookup("force"))
 	viper.BindPFlag("lifetime", initCmd.Flags().Lookup("lifetime"))
 
 	viper.SetDefault("storage.database", "db.sqlite3")
+	viper.SetDefault("storage.busy_timeout", time.Second*5)
 
 }
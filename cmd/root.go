@@ -19,6 +19,7 @@ package cmd
 import (
 	"os"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -27,6 +28,7 @@ import (
 
 var (
 	cfgFile string
+	caName  string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -94,7 +96,26 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is $HOME/zcert.yaml)")
 	rootCmd.PersistentFlags().StringP("verbosity", "v", "INFO", "level of verbosity (TRACE, DEBUG, INFO, WARN, ERROR, FATAL)")
 	rootCmd.PersistentFlags().StringP("authkey", "a", "", "key to use for message authentication codes")
+	rootCmd.PersistentFlags().StringVar(&caName, "ca", "", "name of the certificate authority in cas to use (default is the only one)")
+	rootCmd.PersistentFlags().Int("passphrase-fd", -1, "file descriptor to read CA key passphrases from, one per line")
 
 	viper.BindPFlag("loglevel", rootCmd.PersistentFlags().Lookup("verbosity"))
 	viper.BindPFlag("authkey", rootCmd.PersistentFlags().Lookup("authkey"))
+	viper.BindPFlag("ca.passphrase_fd", rootCmd.PersistentFlags().Lookup("passphrase-fd"))
+
+	viper.SetDefault("ca.passphrase_env", "ZCERT_CA_PASSPHRASE")
+	viper.SetDefault("ca.signer", "file")
+	viper.SetDefault("ca.keytype", "ed25519")
+	viper.SetDefault("ca.expiry_warning", time.Hour*24*30)
+	viper.SetDefault("ca.pkcs11.pin_env", "ZCERT_PKCS11_PIN")
+	viper.SetDefault("ca.pkcs11.label_prefix", "zcert/")
+	viper.SetDefault("ssh.lifetime", time.Hour*24)
+	viper.SetDefault("ssh.max_lifetime", time.Hour*24*7)
+	viper.SetDefault("acme.lifetime", time.Hour*24*90)
+	viper.SetDefault("acme.http_port", 80)
+	viper.SetDefault("est.lifetime", time.Hour*24*90)
+	viper.SetDefault("est.client_auth", true)
+	viper.SetDefault("est.server_auth", true)
+	viper.SetDefault("cfssl.lifetime", time.Hour*24*365)
+	viper.SetDefault("vault.ttl", time.Hour*24*30)
 }
@@ -17,10 +17,11 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/stormentt/zcert/certs"
-	"github.com/stormentt/zcert/db"
+	"github.com/spf13/viper"
 	"github.com/stormentt/zcert/server"
 )
 
@@ -30,18 +31,6 @@ var serverCmd = &cobra.Command{
 	Short: "Interact with zcert clients",
 	Long:  ``,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := certs.LoadCA(); err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-			}).Fatal("unable to load CA")
-		}
-
-		if err := db.InitDB(); err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-			}).Fatal("unable to init DB")
-		}
-
 		if err := server.Serve(); err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
@@ -62,4 +51,11 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// serverCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+
+	viper.SetDefault("policy.keys.algorithms", []string{"ed25519"})
+	viper.SetDefault("policy.keys.rsa_min_bits", 2048)
+	viper.SetDefault("policy.keys.curves", []string{"P-256", "P-384", "P-521"})
+	viper.SetDefault("policy.uniqueness.client", "unlimited")
+	viper.SetDefault("policy.uniqueness.server", "unlimited")
+	viper.SetDefault("crl.lifetime", time.Hour*24*7)
 }
@@ -0,0 +1,67 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stormentt/zcert/agent"
+	"github.com/stormentt/zcert/certs"
+)
+
+// signerCmd represents the signer command
+var signerCmd = &cobra.Command{
+	Use:   "signer",
+	Short: "Hold the certificate authority's keys and sign for the server",
+	Long: `Hold the certificate authority's keys and sign for the server.
+
+The signing agent loads the keys from agent.backend and signs with them over the
+unix socket in agent.socket (signer.sock in storage.path by default). Run the
+server with ca.signer set to agent and it never holds the keys itself. Only
+processes running as a uid in agent.allowed_uids may connect, or as the agent's
+own user when that's empty.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		a := newAuthority()
+
+		backend := a.Config().GetString("agent.backend")
+		if backend == certs.SignerAgent {
+			log.Fatal("agent.backend can't be agent")
+		}
+
+		a.Config().Set("ca.signer", backend)
+
+		if err := a.LoadCA(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to load CA")
+		}
+
+		uids := a.Config().GetIntSlice("agent.allowed_uids")
+		if err := agent.Serve(a.AgentSocket(), a.AgentKeys(), uids); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("unable to run signing agent")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(signerCmd)
+
+	viper.SetDefault("agent.backend", "file")
+}
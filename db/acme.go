@@ -0,0 +1,146 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// statuses of ACME objects, as in RFC 8555 section 7.1.6
+const (
+	ACMEPending     = "pending"
+	ACMEReady       = "ready"
+	ACMEProcessing  = "processing"
+	ACMEValid       = "valid"
+	ACMEInvalid     = "invalid"
+	ACMEDeactivated = "deactivated"
+)
+
+// ACMEAccount is an ACME client's account, identified by its key
+type ACMEAccount struct {
+	ID int64 `gorm:"primaryKey"`
+
+	// Thumbprint is the RFC 7638 thumbprint of the account key, and Key the
+	// key itself as a JWK
+	Thumbprint string `gorm:"uniqueIndex"`
+	Key        string
+
+	Contact []string `gorm:"serializer:json"`
+	Status  string
+
+	CreatedAt time.Time
+}
+
+// ACMEIdentifier is a name an ACME order asks a certificate for
+type ACMEIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type ACMEOrder struct {
+	ID        int64 `gorm:"primaryKey"`
+	AccountID int64 `gorm:"index"`
+
+	Status      string
+	Identifiers []ACMEIdentifier `gorm:"serializer:json"`
+	Expires     time.Time
+
+	// Error is the problem document explaining why the order is invalid
+	Error string
+
+	// Serial and Certificate are set once the order has been finalized.
+	// Certificate is the PEM encoded chain.
+	Serial      string `gorm:"index"`
+	Certificate string
+}
+
+type ACMEAuthorization struct {
+	ID        int64 `gorm:"primaryKey"`
+	OrderID   int64 `gorm:"index"`
+	AccountID int64 `gorm:"index"`
+
+	Identifier ACMEIdentifier `gorm:"serializer:json"`
+	Wildcard   bool
+	Status     string
+	Expires    time.Time
+}
+
+type ACMEChallenge struct {
+	ID              int64 `gorm:"primaryKey"`
+	AuthorizationID int64 `gorm:"index"`
+
+	Type      string
+	Token     string
+	Status    string
+	Validated time.Time
+
+	// Error is the problem document explaining why validation failed
+	Error string
+}
+
+// ACMEAccountByThumbprint returns the account with the given key thumbprint,
+// or nil when there isn't one
+func ACMEAccountByThumbprint(tx *gorm.DB, thumbprint string) (*ACMEAccount, error) {
+	var found []ACMEAccount
+	if err := tx.Where("thumbprint = ?", thumbprint).Limit(1).Find(&found).Error; err != nil {
+		return nil, err
+	}
+
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	return &found[0], nil
+}
+
+// ACMEOrderAuthorizations returns the authorizations of an order
+func ACMEOrderAuthorizations(tx *gorm.DB, orderID int64) ([]ACMEAuthorization, error) {
+	var found []ACMEAuthorization
+	err := tx.Where("order_id = ?", orderID).Order("id").Find(&found).Error
+	return found, err
+}
+
+// ACMEAuthorizationChallenges returns the challenges of an authorization
+func ACMEAuthorizationChallenges(tx *gorm.DB, authorizationID int64) ([]ACMEChallenge, error) {
+	var found []ACMEChallenge
+	err := tx.Where("authorization_id = ?", authorizationID).Order("id").Find(&found).Error
+	return found, err
+}
+
+// ACMEAccountOrders returns the ids of an account's orders
+func ACMEAccountOrders(tx *gorm.DB, accountID int64) ([]int64, error) {
+	var ids []int64
+	err := tx.Model(&ACMEOrder{}).Where("account_id = ?", accountID).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// CertBySerial returns the certificate with the hex encoded serial, or nil
+// when there isn't one
+func CertBySerial(tx *gorm.DB, serial string) (*SignedCertificate, error) {
+	var found []SignedCertificate
+	if err := tx.Where("serial = ?", serial).Limit(1).Find(&found).Error; err != nil {
+		return nil, err
+	}
+
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	return &found[0], nil
+}
+
+// ACMEAccountHasSerial reports whether the certificate with the serial was
+// issued for one of an account's orders
+func ACMEAccountHasSerial(tx *gorm.DB, accountID int64, serial string) (bool, error) {
+	var count int64
+	err := tx.Model(&ACMEOrder{}).Where("account_id = ? AND serial = ?", accountID, serial).Count(&count).Error
+	return count > 0, err
+}
+
+// SetACMEOrderStatus moves an order from one status to another, and reports
+// whether it was still in from. Only one of several concurrent requests can
+// move an order this way.
+func SetACMEOrderStatus(tx *gorm.DB, orderID int64, from, to string) (bool, error) {
+	result := tx.Model(&ACMEOrder{}).Where("id = ? AND status = ?", orderID, from).Update("status", to)
+	return result.RowsAffected == 1, result.Error
+}
@@ -3,47 +3,143 @@ package db
 import (
 	"crypto/x509/pkix"
 	"errors"
-	"sync/atomic"
+	"fmt"
 	"time"
 
-	"github.com/spf13/viper"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
-var DB *gorm.DB
-var serial int64
+const serialCounter = "serial"
 
 type SignedCertificate struct {
 	ID int64 `gorm:"primaryKey"`
 
+	// Serial is the hex encoded certificate serial number
+	Serial string `gorm:"uniqueIndex"`
+
 	NotBefore time.Time
 	NotAfter  time.Time
 
 	Issuer  pkix.Name `gorm:"serializer:json"`
 	Subject pkix.Name `gorm:"serializer:json"`
+
+	// AuthorityKeyID is the hex encoded subject key id of the issuing CA
+	AuthorityKeyID string `gorm:"index"`
+
+	SANs    []string `gorm:"column:sans;serializer:json"`
+	NameSet string   `gorm:"index"`
+
+	ClientAuth bool
+	ServerAuth bool
+	IsCA       bool
+
+	SPKIFingerprint string `gorm:"index"`
+
+	Revoked          bool
+	RevokedAt        time.Time
+	RevocationReason string
 }
 
-func InitDB() error {
-	dbPath := viper.GetString("storage.database")
+// Counter is a named sequence shared by every process using the database
+type Counter struct {
+	Name  string `gorm:"primaryKey"`
+	Value int64
+}
 
-	var err error
-	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+// Active limits a query to certificates that are neither revoked nor expired
+func Active(tx *gorm.DB) *gorm.DB {
+	return tx.Where("revoked IS NOT TRUE AND not_after > ?", time.Now())
+}
+
+// CertsForKey returns every certificate issued for the public key with the
+// given SPKI fingerprint, revoked or not
+func CertsForKey(tx *gorm.DB, fingerprint string) ([]SignedCertificate, error) {
+	var found []SignedCertificate
+	err := tx.Where("spki_fingerprint = ?", fingerprint).Find(&found).Error
+	return found, err
+}
+
+// AllCertificates returns every recorded certificate in the order they were
+// recorded
+func AllCertificates(tx *gorm.DB) ([]SignedCertificate, error) {
+	var found []SignedCertificate
+	err := tx.Order("id").Find(&found).Error
+	return found, err
+}
+
+// Open opens the sqlite database at path, creating and migrating it when
+// needed. busyTimeout is how long to wait for another process's write lock.
+func Open(path string, busyTimeout time.Duration) (*gorm.DB, error) {
+	// WAL lets readers continue while a certificate is being issued, the busy
+	// timeout makes other processes wait for the write lock instead of failing,
+	// and immediate transactions take that lock up front so two issuers can't
+	// both read the same serial before either writes
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate",
+		path, busyTimeout.Milliseconds())
+
+	conn, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = migrateSerials(conn); err != nil {
+		return nil, err
+	}
+
+	conn.AutoMigrate(&SignedCertificate{}, &Counter{}, &SSHCertificate{},
+		&ACMEAccount{}, &ACMEOrder{}, &ACMEAuthorization{}, &ACMEChallenge{})
+	if err = seedSerialCounter(conn); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// migrateSerials adds the serial column to databases created before serials
+// were stored separately from the primary key. Those certificates were issued
+// with their ID as their serial number.
+func migrateSerials(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasTable(&SignedCertificate{}) || m.HasColumn(&SignedCertificate{}, "Serial") {
+		return nil
+	}
+
+	if err := m.AddColumn(&SignedCertificate{}, "Serial"); err != nil {
+		return err
+	}
+
+	return tx.Exec("UPDATE signed_certificates SET serial = printf('%x', id)").Error
+}
+
+// SerialExists reports whether a certificate with the hex encoded serial has
+// already been recorded
+func SerialExists(tx *gorm.DB, serial string) (bool, error) {
+	var count int64
+	err := tx.Model(&SignedCertificate{}).Where("serial = ?", serial).Count(&count).Error
+	return count > 0, err
+}
+
+// seedSerialCounter creates the sequential serial counter if it doesn't exist
+// yet. Before the counter existed, sequential serials matched the ID of the
+// last certificate.
+func seedSerialCounter(tx *gorm.DB) error {
+	last, err := lastSerial(tx)
 	if err != nil {
 		return err
 	}
 
-	DB.AutoMigrate(&SignedCertificate{})
-	serial, err = lastSerial()
-	return err
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Counter{Name: serialCounter, Value: last}).Error
 }
 
-func lastSerial() (int64, error) {
+func lastSerial(tx *gorm.DB) (int64, error) {
 	var lastCert SignedCertificate
-	if err := DB.Last(&lastCert).Error; err != nil {
+	if err := tx.Last(&lastCert).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return 0, nil
 		}
@@ -54,6 +150,26 @@ func lastSerial() (int64, error) {
 	return lastCert.ID, nil
 }
 
-func NextSerial() int64 {
-	return atomic.AddInt64(&serial, 1)
+// NextSerial reserves the next sequential serial. It must be called inside
+// the transaction that records the certificate so that a failed issuance
+// gives the serial back.
+func NextSerial(tx *gorm.DB) (int64, error) {
+	err := tx.Model(&Counter{}).
+		Where("name = ?", serialCounter).
+		Update("value", gorm.Expr("value + 1")).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var counter Counter
+	err = tx.Where("name = ?", serialCounter).First(&counter).Error
+	return counter.Value, err
+}
+
+// RaiseSerial makes sure the sequential serial counter is at least serial, so
+// that serials issued before zcert took over a CA are never reused
+func RaiseSerial(tx *gorm.DB, serial int64) error {
+	return tx.Model(&Counter{}).
+		Where("name = ? AND value < ?", serialCounter, serial).
+		Update("value", serial).Error
 }
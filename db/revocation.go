@@ -0,0 +1,109 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// revocation reasons, named as in RFC 5280
+const (
+	ReasonUnspecified          = "unspecified"
+	ReasonKeyCompromise        = "keyCompromise"
+	ReasonCACompromise         = "cACompromise"
+	ReasonAffiliationChanged   = "affiliationChanged"
+	ReasonSuperseded           = "superseded"
+	ReasonCessationOfOperation = "cessationOfOperation"
+	ReasonCertificateHold      = "certificateHold"
+	ReasonPrivilegeWithdrawn   = "privilegeWithdrawn"
+	ReasonAACompromise         = "aACompromise"
+)
+
+// ReasonCodes maps revocation reasons to their CRLReason values
+var ReasonCodes = map[string]int{
+	ReasonUnspecified:          0,
+	ReasonKeyCompromise:        1,
+	ReasonCACompromise:         2,
+	ReasonAffiliationChanged:   3,
+	ReasonSuperseded:           4,
+	ReasonCessationOfOperation: 5,
+	ReasonCertificateHold:      6,
+	ReasonPrivilegeWithdrawn:   9,
+	ReasonAACompromise:         10,
+}
+
+func (sc *SignedCertificate) Revoke(tx *gorm.DB, reason string) error {
+	return tx.Model(sc).Updates(map[string]interface{}{
+		"revoked":           true,
+		"revoked_at":        time.Now(),
+		"revocation_reason": reason,
+	}).Error
+}
+
+// RevokedBy returns the unexpired revoked certificates issued by the CA with
+// the given hex encoded subject key id
+func RevokedBy(tx *gorm.DB, authorityKeyID string) ([]SignedCertificate, error) {
+	var found []SignedCertificate
+	err := tx.Where("authority_key_id = ? AND revoked IS TRUE AND not_after > ?", authorityKeyID, time.Now()).
+		Find(&found).Error
+	return found, err
+}
+
+// RevokedCount returns how many certificates the CA with the given hex encoded
+// subject key id has had revoked, expired ones included, so that it grows with
+// every revocation
+func RevokedCount(tx *gorm.DB, authorityKeyID string) (int64, error) {
+	var count int64
+	err := tx.Model(&SignedCertificate{}).
+		Where("authority_key_id = ? AND revoked IS TRUE", authorityKeyID).
+		Count(&count).Error
+	return count, err
+}
+
+// NextCRLNumber reserves the next CRL number of the CA with the given hex
+// encoded subject key id. CRLs used to be numbered with the time they were
+// made, so a new counter starts at the current time to keep the numbers
+// increasing.
+func NextCRLNumber(tx *gorm.DB, authorityKeyID string) (int64, error) {
+	name := "crl/" + authorityKeyID
+
+	var counter Counter
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&Counter{Name: name, Value: time.Now().Unix()}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&Counter{}).
+			Where("name = ?", name).
+			Update("value", gorm.Expr("value + 1")).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("name = ?", name).First(&counter).Error
+	})
+
+	return counter.Value, err
+}
+
+// HasUnexpired reports whether the CA with the given hex encoded subject key id
+// issued any certificates that haven't expired yet
+func HasUnexpired(tx *gorm.DB, authorityKeyID string) (bool, error) {
+	var count int64
+	err := tx.Model(&SignedCertificate{}).
+		Where("authority_key_id = ? AND not_after > ?", authorityKeyID, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ClaimLegacyCerts attributes certificates recorded before issuers were
+// tracked to the CA with the given hex encoded subject key id. Until a CA is
+// rolled over there is only one CA they could have come from.
+func ClaimLegacyCerts(tx *gorm.DB, authorityKeyID string) error {
+	return tx.Model(&SignedCertificate{}).
+		Where("authority_key_id = '' OR authority_key_id IS NULL").
+		Update("authority_key_id", authorityKeyID).Error
+}
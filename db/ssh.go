@@ -0,0 +1,40 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SSHCertificate is an OpenSSH certificate signed by the SSH certificate
+// authority
+type SSHCertificate struct {
+	ID int64 `gorm:"primaryKey"`
+
+	// Serial is the hex encoded certificate serial number
+	Serial string `gorm:"uniqueIndex"`
+
+	KeyID      string
+	CertType   string
+	Principals []string `gorm:"serializer:json"`
+
+	ValidAfter  time.Time
+	ValidBefore time.Time
+
+	CriticalOptions map[string]string `gorm:"serializer:json"`
+	Extensions      map[string]string `gorm:"serializer:json"`
+
+	// Fingerprint is the SHA256 fingerprint of the certified key, and
+	// CAFingerprint the one of the key that signed it, as ssh-keygen -l
+	// shows them
+	Fingerprint   string `gorm:"index"`
+	CAFingerprint string `gorm:"index"`
+}
+
+// SSHSerialExists reports whether an SSH certificate with the hex encoded
+// serial has already been recorded
+func SSHSerialExists(tx *gorm.DB, serial string) (bool, error) {
+	var count int64
+	err := tx.Model(&SSHCertificate{}).Where("serial = ?", serial).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,348 @@
+package est
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/auth"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+type NameMismatchError struct{}
+
+func (e *NameMismatchError) Error() string {
+	return "the csr has to ask for the same names as the client certificate"
+}
+
+func (e *NameMismatchError) PolicyViolation() {}
+
+// Server serves the EST enrollment API of a certificate authority, as in
+// RFC 7030
+type Server struct {
+	authority *certs.Authority
+}
+
+func New(a *certs.Authority) *Server {
+	return &Server{authority: a}
+}
+
+// Routes adds the EST API to r under prefix
+func (s *Server) Routes(r *gin.Engine, prefix string) {
+	group := r.Group(prefix)
+	group.GET("/cacerts", s.cacerts)
+	group.GET("/csrattrs", s.csrattrs)
+	group.POST("/simpleenroll", s.simpleenroll)
+	group.POST("/simplereenroll", s.simplereenroll)
+}
+
+// sendCerts responds with a base64 encoded PKCS#7 certs-only message
+func sendCerts(c *gin.Context, crts []*x509.Certificate) {
+	msg, err := certsOnly(crts)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("unable to encode pkcs7")
+
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Header("Content-Transfer-Encoding", "base64")
+	c.Data(http.StatusOK, "application/pkcs7-mime; smime-type=certs-only", []byte(util.EncodeB64(msg)))
+}
+
+func (s *Server) cacerts(c *gin.Context) {
+	bundle, err := s.authority.TrustBundle()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("unable to build trust bundle")
+
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	sendCerts(c, bundle)
+}
+
+// csrattrs tells clients there's nothing in particular they have to put in
+// their CSRs
+func (s *Server) csrattrs(c *gin.Context) {
+	c.Status(http.StatusNoContent)
+}
+
+// clientCert returns the TLS client certificate of the request if the
+// authority issued it and it's still good for client auth
+func (s *Server) clientCert(c *gin.Context) (*x509.Certificate, error) {
+	if c.Request.TLS == nil || len(c.Request.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+
+	crt := c.Request.TLS.PeerCertificates[0]
+	now := time.Now()
+	if !s.authority.Issued(crt) || now.Before(crt.NotBefore) || now.After(crt.NotAfter) {
+		return nil, nil
+	}
+
+	clientAuth := false
+	for _, usage := range crt.ExtKeyUsage {
+		clientAuth = clientAuth || usage == x509.ExtKeyUsageClientAuth
+	}
+
+	if !clientAuth {
+		return nil, nil
+	}
+
+	record, err := db.CertBySerial(s.authority.DB, certs.SerialString(crt.SerialNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	if record == nil || record.Revoked {
+		return nil, nil
+	}
+
+	return crt, nil
+}
+
+// checkedClientCert is clientCert, responding with an error when the
+// certificate couldn't be checked
+func (s *Server) checkedClientCert(c *gin.Context) (*x509.Certificate, bool) {
+	crt, err := s.clientCert(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("unable to check client certificate")
+
+		c.String(http.StatusInternalServerError, "internal server error")
+		return nil, false
+	}
+
+	return crt, true
+}
+
+// authenticate checks the request's credentials: a Content-HMAC header made
+// with the authkey, HTTP basic auth with the authkey as the password, or a
+// client certificate issued by the authority. Basic auth is only accepted
+// over TLS, as it sends the authkey itself. The client certificate is
+// returned when it was the credential used, since it only vouches for its
+// own names.
+func (s *Server) authenticate(c *gin.Context, body []byte) (*x509.Certificate, bool) {
+	authkey := s.authority.Config().GetString("authkey")
+	if len(c.GetHeader("Content-HMAC")) > 0 {
+		expectedHMAC, err := auth.GetHMACFromHeader(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid Content-HMAC header")
+			return nil, false
+		}
+
+		match, err := auth.CheckHMACWithKey(authkey, expectedHMAC, body)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("unable to check HMAC")
+
+			c.String(http.StatusInternalServerError, "internal server error")
+			return nil, false
+		}
+
+		if match {
+			return nil, true
+		}
+	} else if user, password, ok := c.Request.BasicAuth(); ok {
+		if c.Request.TLS == nil {
+			c.String(http.StatusForbidden, "basic auth is only accepted over https")
+			return nil, false
+		}
+
+		if len(authkey) > 0 && subtle.ConstantTimeCompare([]byte(password), []byte(authkey)) == 1 {
+			return nil, true
+		}
+
+		log.WithFields(log.Fields{
+			"user": user,
+		}).Debug("est basic auth failed")
+	} else {
+		crt, ok := s.checkedClientCert(c)
+		if !ok {
+			return nil, false
+		}
+
+		if crt != nil {
+			return crt, true
+		}
+	}
+
+	c.Header("WWW-Authenticate", `Basic realm="zcert"`)
+	c.String(http.StatusUnauthorized, "unauthorized")
+	return nil, false
+}
+
+// checkNames makes sure the CSR asks for the same names as crt
+func checkNames(c *gin.Context, csr *x509.CertificateRequest, crt *x509.Certificate) bool {
+	requested := certs.NameSet(&x509.Certificate{
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+	})
+
+	if requested != certs.NameSet(crt) {
+		signFailed(c, &NameMismatchError{})
+		return false
+	}
+
+	return true
+}
+
+// readCSR reads the request's base64 encoded PKCS#10 CSR and checks it
+func (s *Server) readCSR(c *gin.Context, body []byte) (*x509.CertificateRequest, bool) {
+	csr, err := certs.ParseCSR(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Debug("invalid est csr")
+
+		c.String(http.StatusBadRequest, "invalid csr")
+		return nil, false
+	}
+
+	if err = s.authority.ValidateCSR(csr); err != nil {
+		signFailed(c, err)
+		return nil, false
+	}
+
+	return csr, true
+}
+
+func (s *Server) sign(c *gin.Context, csr *x509.CertificateRequest) {
+	conf := s.authority.Config()
+	signed, err := s.authority.SignCSR(csr, certs.CSRParams{
+		Lifetime:   conf.GetDuration("est.lifetime"),
+		ClientAuth: conf.GetBool("est.client_auth"),
+		ServerAuth: conf.GetBool("est.server_auth"),
+		Profile:    conf.GetString("est.profile"),
+	})
+	if err != nil {
+		signFailed(c, err)
+		return
+	}
+
+	crts, err := util.DecodeX509Certs(bytes.NewReader(signed))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("unable to decode signed certificate")
+
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"serial":  certs.SerialString(crts[0].SerialNumber),
+		"subject": crts[0].Subject.String(),
+	}).Info("issued certificate over est")
+
+	sendCerts(c, crts)
+}
+
+// signFailed reports a signing error, passing policy violations on to the
+// client and hiding everything else
+func signFailed(c *gin.Context, err error) {
+	var violation certs.PolicyViolation
+	if errors.As(err, &violation) {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Debug("est enrollment refused")
+
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"error": err,
+	}).Error("unable to sign est csr")
+
+	c.String(http.StatusInternalServerError, "internal server error")
+}
+
+func readBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.String(http.StatusBadRequest, "unable to read request")
+		return nil, false
+	}
+
+	return body, true
+}
+
+// simpleenroll issues a certificate. Requests authenticated with a client
+// certificate can only ask for that certificate's names, otherwise any
+// enrolled device could get certificates for any name.
+func (s *Server) simpleenroll(c *gin.Context) {
+	body, ok := readBody(c)
+	if !ok {
+		return
+	}
+
+	crt, ok := s.authenticate(c, body)
+	if !ok {
+		return
+	}
+
+	csr, ok := s.readCSR(c, body)
+	if !ok {
+		return
+	}
+
+	if crt != nil && !checkNames(c, csr, crt) {
+		return
+	}
+
+	s.sign(c, csr)
+}
+
+// simplereenroll renews the client certificate the request is made with. The
+// CSR has to ask for the same names as that certificate.
+func (s *Server) simplereenroll(c *gin.Context) {
+	body, ok := readBody(c)
+	if !ok {
+		return
+	}
+
+	if _, ok = s.authenticate(c, body); !ok {
+		return
+	}
+
+	crt, ok := s.checkedClientCert(c)
+	if !ok {
+		return
+	}
+
+	if crt == nil {
+		c.String(http.StatusForbidden, "re-enrollment requires the client certificate being renewed")
+		return
+	}
+
+	csr, ok := s.readCSR(c, body)
+	if !ok {
+		return
+	}
+
+	if !checkNames(c, csr, crt) {
+		return
+	}
+
+	s.sign(c, csr)
+}
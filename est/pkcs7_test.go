@@ -0,0 +1,102 @@
+package est
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCert(t *testing.T, cn string) *x509.Certificate {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crt
+}
+
+func TestCertsOnlyEmpty(t *testing.T) {
+	msg, err := certsOnly(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ContentInfo { signedData, [0] SignedData { 1, {}, { data }, [0] {}, {} } }
+	expected := "3025" + "06092a864886f70d010702" + "a018" + "3016" +
+		"020101" + "3100" + "300b06092a864886f70d010701" + "a000" + "3100"
+
+	if encoded := hex.EncodeToString(msg); encoded != expected {
+		t.Fatalf("empty certs-only message is %s, expected %s", encoded, expected)
+	}
+}
+
+func TestCertsOnly(t *testing.T) {
+	crts := []*x509.Certificate{testCert(t, "leaf"), testCert(t, "ca")}
+
+	msg, err := certsOnly(crts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(msg, &ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rest) > 0 || !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("message has content type %s and %d trailing bytes", ci.ContentType, len(rest))
+	}
+
+	var sd signedData
+	if _, err = asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+
+	if sd.Version != 1 || !sd.ContentInfo.ContentType.Equal(oidData) || len(sd.SignerInfos.Bytes) > 0 {
+		t.Fatalf("signed data has version %d, content type %s and %d bytes of signer infos", sd.Version, sd.ContentInfo.ContentType, len(sd.SignerInfos.Bytes))
+	}
+
+	if sd.Certificates.Class != asn1.ClassContextSpecific || sd.Certificates.Tag != 0 {
+		t.Fatalf("certificates are tagged class %d tag %d, expected [0]", sd.Certificates.Class, sd.Certificates.Tag)
+	}
+
+	parsed, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed) != len(crts) {
+		t.Fatalf("message holds %d certificates, expected %d", len(parsed), len(crts))
+	}
+
+	for i := range crts {
+		if !bytes.Equal(parsed[i].Raw, crts[i].Raw) {
+			t.Fatalf("certificate %d changed", i)
+		}
+	}
+}
@@ -6,12 +6,34 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
 	"github.com/stormentt/zcert/auth"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func CheckAuth(c *gin.Context) {
+// CheckAuth authenticates requests with the authkey in conf
+func CheckAuth(conf *viper.Viper) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkAuthWithKey(c, conf.GetString("authkey"))
+	}
+}
+
+// CheckAdminAuth is CheckAuth for privileged routes, using the adminkey
+func CheckAdminAuth(conf *viper.Viper) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminkey, err := auth.AdminKey(conf)
+		if err != nil {
+			c.String(http.StatusForbidden, "admin routes are disabled")
+			c.Abort()
+			return
+		}
+
+		checkAuthWithKey(c, adminkey)
+	}
+}
+
+func checkAuthWithKey(c *gin.Context, key string) {
 	expectedHMAC, err := auth.GetHMACFromHeader(c)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -36,7 +58,7 @@ func CheckAuth(c *gin.Context) {
 
 	c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
 
-	match, err := auth.CheckHMAC(expectedHMAC, bodyBytes)
+	match, err := auth.CheckHMACWithKey(key, expectedHMAC, bodyBytes)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
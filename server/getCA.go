@@ -12,30 +12,45 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func getCA(c *gin.Context) {
-	buf := new(bytes.Buffer)
-	err := util.EncodeX509Cert(buf, certs.CA.Raw)
-
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("unable to encode CACert")
-
-		c.String(http.StatusInternalServerError, "internal server error")
-
-		return
-	}
-
-	calcHMAC, err := auth.CalcHMAC(buf.Bytes())
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("unable to calculate hmac of cert authority")
-
-		c.String(http.StatusInternalServerError, "internal server error")
-
-		return
+func getCA(a *certs.Authority) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bundle, err := a.TrustBundle()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("unable to build trust bundle")
+
+			c.String(http.StatusInternalServerError, "internal server error")
+
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		for _, crt := range bundle {
+			err := util.EncodeX509Cert(buf, crt.Raw)
+
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Error("unable to encode CACert")
+
+				c.String(http.StatusInternalServerError, "internal server error")
+
+				return
+			}
+		}
+
+		calcHMAC, err := auth.CalcHMACWithKey(a.Config().GetString("authkey"), buf.Bytes())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("unable to calculate hmac of cert authority")
+
+			c.String(http.StatusInternalServerError, "internal server error")
+
+			return
+		}
+		c.Header("Content-HMAC", util.EncodeB64(calcHMAC))
+		c.DataFromReader(http.StatusOK, int64(buf.Len()), "application/x-x509-ca-cert", buf, nil)
 	}
-	c.Header("Content-HMAC", util.EncodeB64(calcHMAC))
-	c.DataFromReader(http.StatusOK, int64(buf.Len()), "application/x-x509-ca-cert", buf, nil)
 }
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stormentt/zcert/auth"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// getCRL serves the CRL of a's current certificate authority, or of its
+// current or retired certificate authority named by the :keyid parameter
+func getCRL(a *certs.Authority) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID := c.Param("keyid")
+		if len(keyID) == 0 {
+			keyID = certs.KeyID(a.Cert)
+		}
+
+		crl, err := a.CRL(keyID)
+		if err != nil {
+			var unknown *certs.UnknownIssuerError
+			if errors.As(err, &unknown) {
+				c.String(http.StatusNotFound, err.Error())
+				return
+			}
+
+			log.WithFields(log.Fields{
+				"error": err,
+				"keyID": keyID,
+			}).Error("unable to create crl")
+
+			c.String(http.StatusInternalServerError, "internal server error")
+
+			return
+		}
+
+		buf := bytes.NewBuffer(crl)
+		calcHMAC, err := auth.CalcHMACWithKey(a.Config().GetString("authkey"), buf.Bytes())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("unable to calculate hmac of crl")
+
+			c.String(http.StatusInternalServerError, "internal server error")
+
+			return
+		}
+
+		c.Header("Content-HMAC", util.EncodeB64(calcHMAC))
+		c.DataFromReader(http.StatusOK, int64(buf.Len()), "application/pkix-crl", buf, nil)
+	}
+}
@@ -1,19 +1,96 @@
 package server
 
 import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"path"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stormentt/zcert/acme"
 	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/cfssl"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/est"
 	"github.com/stormentt/zcert/middleware"
 	"github.com/stormentt/zcert/server/nonces"
+	"github.com/stormentt/zcert/vault"
 )
 
 var noncemanager nonces.NonceManager
 
-func setup() error {
-	return certs.LoadCA()
+func setup() ([]*certs.Authority, error) {
+	authorities, err := certs.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range authorities {
+		a.WarnExpiry()
+
+		if err = db.ClaimLegacyCerts(a.DB, certs.KeyID(a.Cert)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = vault.CheckMounts(authorities); err != nil {
+		return nil, err
+	}
+
+	return authorities, nil
+}
+
+// watchExpiry keeps warning about the certificate authorities' expiry while
+// the server runs
+func watchExpiry(authorities []*certs.Authority) {
+	for range time.Tick(time.Hour * 24) {
+		for _, a := range authorities {
+			a.WarnExpiry()
+		}
+	}
+}
+
+// routes adds the routes of a certificate authority. The unnamed authority is
+// served from the root, named ones from /ca/<name> and under /<name>/.
+func routes(r *gin.Engine, a *certs.Authority) {
+	caPath, prefix := "/ca", "/"
+	if len(a.Name) > 0 {
+		caPath, prefix = "/ca/"+a.Name, "/"+a.Name
+	}
+
+	r.GET(caPath, getCA(a))
+
+	group := r.Group(prefix)
+	group.GET("/crl", getCRL(a))
+	group.GET("/crl/:keyid", getCRL(a))
+	group.GET("/ssh/ca", getSSHCA(a))
+
+	authRoutes := group.Group("/", middleware.CheckAuth(a.Config()))
+	authRoutes.POST("/sign", signCert(a))
+	authRoutes.POST("/ssh/sign", signSSH(a, false))
+
+	adminRoutes := group.Group("/admin", middleware.CheckAdminAuth(a.Config()))
+	adminRoutes.POST("/sign-ca", signCACert(a))
+	adminRoutes.POST("/ssh/sign", signSSH(a, true))
+
+	if a.Config().GetBool("acme.enabled") {
+		acme.New(a).Routes(r, path.Join(prefix, "acme"))
+	}
+
+	if a.Config().GetBool("cfssl.enabled") {
+		cfssl.New(a).Routes(r, path.Join(prefix, "api/v1/cfssl"))
+	}
+
+	if a.Config().GetBool("est.enabled") {
+		est.New(a).Routes(r, path.Join("/.well-known/est", a.Name))
+	}
+
+	if a.Config().GetBool("vault.enabled") {
+		vault.New(a).Routes(r)
+	}
 }
 
 func ginLogger(c *gin.Context) {
@@ -32,11 +109,13 @@ func ginLogger(c *gin.Context) {
 }
 
 func Serve() error {
-	err := setup()
+	authorities, err := setup()
 	if err != nil {
 		return err
 	}
 
+	go watchExpiry(authorities)
+
 	r := gin.New()
 	r.Use(ginLogger)
 	r.Use(gin.Recovery())
@@ -49,11 +128,32 @@ func Serve() error {
 		}).Debug(absolutePath)
 	}
 
-	r.GET("/ca", getCA)
-	authRoutes := r.Group("/", middleware.CheckAuth)
-	authRoutes.POST("/sign", signCert)
+	for _, a := range authorities {
+		routes(r, a)
+	}
+
+	// with tls.cert and tls.key the server speaks TLS, and asks for client
+	// certificates for the routes that accept them
+	if cert, key := viper.GetString("tls.cert"), viper.GetString("tls.key"); len(cert) > 0 || len(key) > 0 {
+		srv := &http.Server{
+			Addr:      listenAddr(),
+			Handler:   r,
+			TLSConfig: &tls.Config{ClientAuth: tls.RequestClientCert},
+		}
+
+		return srv.ListenAndServeTLS(cert, key)
+	}
 
 	r.Run()
 
 	return nil
 }
+
+// listenAddr returns the address to listen on, from $PORT like gin does
+func listenAddr() string {
+	if port := os.Getenv("PORT"); len(port) > 0 {
+		return ":" + port
+	}
+
+	return ":8080"
+}
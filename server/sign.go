@@ -2,6 +2,8 @@ package server
 
 import (
 	"bytes"
+	"crypto/x509"
+	"errors"
 	"fmt"
 
 	"net/http"
@@ -14,61 +16,80 @@ import (
 	"github.com/stormentt/zcert/util"
 )
 
-func signCert(c *gin.Context) {
-	var req apitypes.SignCertReq
-	if err := c.BindJSON(&req); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Debug("invalid sign-csr json")
-
-		c.String(http.StatusBadRequest, "invalid sign-csr json")
-		return
-	}
-
-	if err := req.Validate(); err != nil {
+// checkSecurityBlock validates the request time & nonce, and records the
+// nonce so the request can't be replayed
+func checkSecurityBlock(c *gin.Context, sb apitypes.SecurityBlock) bool {
+	if err := sb.Validate(); err != nil {
 		c.String(http.StatusBadRequest, fmt.Sprintf("validation failed: %s", err))
-		return
+		return false
 	}
 
-	if noncemanager.Seen(req.Nonce) {
+	if noncemanager.Seen(sb.Nonce) {
 		c.String(http.StatusUnauthorized, "nonce reused")
-		return
+		return false
 	}
 
-	noncemanager.Record(req.Nonce)
+	noncemanager.Record(sb.Nonce)
+	return true
+}
 
-	parsedCSR, err := certs.ParseCSR(req.CSR)
+func parseCSR(c *gin.Context, a *certs.Authority, csrB64 string) (*x509.CertificateRequest, bool) {
+	parsedCSR, err := certs.ParseCSR(csrB64)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 		}).Debug("invalid csr base64")
 
 		c.String(http.StatusBadRequest, "invalid csr base64")
-		return
+		return nil, false
 	}
 
-	if err = certs.ValidateCSR(parsedCSR); err != nil {
+	if err = a.ValidateCSR(parsedCSR); err != nil {
+		var violation certs.PolicyViolation
+		if errors.As(err, &violation) {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Debug("csr rejected")
+
+			c.String(http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+
 		log.WithFields(log.Fields{
 			"error": err,
-		}).Debug("csr signature invalid")
+		}).Error("unable to validate csr")
 
-		c.String(http.StatusBadRequest, "csr signature invalid")
-		return
+		c.String(http.StatusInternalServerError, "internal server error")
+		return nil, false
 	}
 
-	signedCSR, err := certs.SignCSR(parsedCSR, req.Params)
-	if err != nil {
+	return parsedCSR, true
+}
+
+// signFailed reports a signing error, passing policy violations on to the
+// client and hiding everything else
+func signFailed(c *gin.Context, err error) {
+	var violation certs.PolicyViolation
+	if errors.As(err, &violation) {
 		log.WithFields(log.Fields{
 			"error": err,
-		}).Error("unable to sign csr")
-
-		c.String(http.StatusInternalServerError, "internal server error")
+		}).Debug("signing refused")
 
+		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	buf := bytes.NewBuffer(signedCSR)
-	calcHMAC, err := auth.CalcHMAC(buf.Bytes())
+	log.WithFields(log.Fields{
+		"error": err,
+	}).Error("unable to sign csr")
+
+	c.String(http.StatusInternalServerError, "internal server error")
+}
+
+// sendCert responds with the signed certificate, authenticated with key
+func sendCert(c *gin.Context, signed []byte, key string) {
+	buf := bytes.NewBuffer(signed)
+	calcHMAC, err := auth.CalcHMACWithKey(key, buf.Bytes())
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -82,3 +103,70 @@ func signCert(c *gin.Context) {
 	c.Header("Content-HMAC", util.EncodeB64(calcHMAC))
 	c.DataFromReader(http.StatusOK, int64(buf.Len()), "application/x-x509-user-cert", buf, nil)
 }
+
+func signCert(a *certs.Authority) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req apitypes.SignCertReq
+		if err := c.BindJSON(&req); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Debug("invalid sign-csr json")
+
+			c.String(http.StatusBadRequest, "invalid sign-csr json")
+			return
+		}
+
+		if !checkSecurityBlock(c, req.SecurityBlock) {
+			return
+		}
+
+		parsedCSR, ok := parseCSR(c, a, req.CSR)
+		if !ok {
+			return
+		}
+
+		signedCSR, err := a.SignCSR(parsedCSR, req.Params)
+		if err != nil {
+			signFailed(c, err)
+			return
+		}
+
+		sendCert(c, signedCSR, a.Config().GetString("authkey"))
+	}
+}
+
+func signCACert(a *certs.Authority) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req apitypes.SignCACertReq
+		if err := c.BindJSON(&req); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Debug("invalid sign-ca json")
+
+			c.String(http.StatusBadRequest, "invalid sign-ca json")
+			return
+		}
+
+		if !checkSecurityBlock(c, req.SecurityBlock) {
+			return
+		}
+
+		parsedCSR, ok := parseCSR(c, a, req.CSR)
+		if !ok {
+			return
+		}
+
+		signedCSR, err := a.SignCACSR(parsedCSR, req.Params)
+		if err != nil {
+			signFailed(c, err)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"subject": parsedCSR.Subject.String(),
+		}).Info("issued subordinate certificate authority")
+
+		adminkey, _ := auth.AdminKey(a.Config())
+		sendCert(c, signedCSR, adminkey)
+	}
+}
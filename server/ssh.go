@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stormentt/zcert/apitypes"
+	"github.com/stormentt/zcert/auth"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func getSSHCA(a *certs.Authority) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.SSHSigner == nil {
+			c.String(http.StatusNotFound, "no ssh certificate authority")
+			return
+		}
+
+		pub, err := a.SSHPublicKey()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("unable to encode ssh certificate authority")
+
+			c.String(http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		calcHMAC, err := auth.CalcHMACWithKey(a.Config().GetString("authkey"), pub)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("unable to calculate hmac of ssh certificate authority")
+
+			c.String(http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		c.Header("Content-HMAC", util.EncodeB64(calcHMAC))
+		c.Data(http.StatusOK, "text/plain", pub)
+	}
+}
+
+// signSSH signs SSH certificates. Admin requests are authenticated with the
+// adminkey and may name any principal.
+func signSSH(a *certs.Authority, admin bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.SSHSigner == nil {
+			c.String(http.StatusNotFound, "no ssh certificate authority")
+			return
+		}
+
+		var req apitypes.SignSSHReq
+		if err := c.BindJSON(&req); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Debug("invalid ssh-sign json")
+
+			c.String(http.StatusBadRequest, "invalid ssh-sign json")
+			return
+		}
+
+		if !checkSecurityBlock(c, req.SecurityBlock) {
+			return
+		}
+
+		pub, err := certs.ParseSSHPublicKey([]byte(req.PublicKey))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		signed, err := a.SignSSH(pub, req.Params, admin)
+		if err != nil {
+			signFailed(c, err)
+			return
+		}
+
+		key := a.Config().GetString("authkey")
+		if admin {
+			key, _ = auth.AdminKey(a.Config())
+		}
+
+		buf := bytes.NewBuffer(signed)
+		calcHMAC, err := auth.CalcHMACWithKey(key, buf.Bytes())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("unable to calculate hmac of signed ssh certificate response")
+
+			c.String(http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		c.Header("Content-HMAC", util.EncodeB64(calcHMAC))
+		c.DataFromReader(http.StatusOK, int64(buf.Len()), "text/plain", buf, nil)
+	}
+}
@@ -2,7 +2,7 @@ package util
 
 import (
 	"bytes"
-	"crypto/ed25519"
+	"crypto"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
@@ -10,12 +10,12 @@ import (
 	"os"
 )
 
-type NotEd25519KeyError struct {
+type NotSigningKeyError struct {
 	path string
 }
 
-func (e *NotEd25519KeyError) Error() string {
-	return fmt.Sprintf("data in %s is not an ed25519 private key", e.path)
+func (e *NotSigningKeyError) Error() string {
+	return fmt.Sprintf("data in %s is not an ed25519, ecdsa or rsa private key", e.path)
 }
 
 type NoPEMDataError struct{}
@@ -47,6 +47,52 @@ func DecodeX509Cert(r io.Reader) (*x509.Certificate, error) {
 	return x509.ParseCertificate(block.Bytes)
 }
 
+func DecodeX509CertsFromPath(path string) ([]*x509.Certificate, error) {
+	inFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+
+	return DecodeX509Certs(inFile)
+}
+
+// DecodeX509Certs decodes every PEM encoded certificate in r, in order
+func DecodeX509Certs(r io.Reader) ([]*x509.Certificate, error) {
+	buf := new(bytes.Buffer)
+	_, err := io.Copy(buf, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var crts []*x509.Certificate
+	rest := buf.Bytes()
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		crts = append(crts, crt)
+	}
+
+	if len(crts) == 0 {
+		return nil, &NoPEMDataError{}
+	}
+
+	return crts, nil
+}
+
 func DecodeX509CSRFromPath(path string) (*x509.CertificateRequest, error) {
 	inFile, err := os.Open(path)
 	if err != nil {
@@ -71,11 +117,16 @@ func DecodeX509CSR(r io.Reader) (*x509.CertificateRequest, error) {
 	return x509.ParseCertificateRequest(block.Bytes)
 }
 
-func DecodeEd25519Priv(path string) (ed25519.PrivateKey, error) {
+// DecodePrivateKey reads a PEM encoded ed25519, ecdsa or rsa private key
+// from path, unlocking it with secrets when it is encrypted or split. Keys may
+// be PKCS#8, PKCS#1 or SEC 1 encoded. secrets may be nil when protected keys
+// aren't expected.
+func DecodePrivateKey(path string, secrets *KeySecrets) (crypto.Signer, error) {
 	inFile, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer inFile.Close()
 
 	buf := new(bytes.Buffer)
 	_, err = io.Copy(buf, inFile)
@@ -83,22 +134,42 @@ func DecodeEd25519Priv(path string) (ed25519.PrivateKey, error) {
 		return nil, err
 	}
 
-	block, _ := pem.Decode(buf.Bytes())
+	// openssl ecparam -genkey puts the curve's parameters before the key
+	block, rest := pem.Decode(buf.Bytes())
+	for block != nil && block.Type == "EC PARAMETERS" {
+		block, rest = pem.Decode(rest)
+	}
+
 	if block == nil {
-		return nil, err
+		return nil, &NoPEMDataError{}
 	}
 
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	keybytes, err := unlockBlock(path, block, secrets)
 	if err != nil {
 		return nil, err
 	}
 
-	switch v := key.(type) {
-	case ed25519.PrivateKey:
-		return v, nil
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(keybytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(keybytes)
 	default:
-		return nil, &NotEd25519KeyError{path: path}
+		key, err = x509.ParsePKCS8PrivateKey(keybytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS#8 may also hold keys that can't sign, like X25519
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, &NotSigningKeyError{path: path}
 	}
+
+	return signer, nil
 }
 
 func EncodeX509Cert(buf *bytes.Buffer, b []byte) error {
@@ -108,7 +179,8 @@ func EncodeX509Cert(buf *bytes.Buffer, b []byte) error {
 	})
 }
 
-func EncodeEd25519Priv(buf *bytes.Buffer, key ed25519.PrivateKey) error {
+// EncodePrivateKey PEM encodes key as PKCS#8
+func EncodePrivateKey(buf *bytes.Buffer, key crypto.Signer) error {
 	keybytes, err := x509.MarshalPKCS8PrivateKey(key)
 	if err != nil {
 		return err
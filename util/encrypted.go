@@ -0,0 +1,242 @@
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// EncryptedKeyType is the PEM type of a private key encrypted with
+// EncodeEncryptedPrivateKey. The PKCS#8 encoded key is sealed with
+// XChaCha20-Poly1305 under a key derived from the passphrase with scrypt.
+const EncryptedKeyType = "ZCERT ENCRYPTED PRIVATE KEY"
+
+// SplitKeyType is the PEM type of a private key sealed by
+// EncodeSplitPrivateKey under a random key that was split into shares
+const SplitKeyType = "ZCERT SPLIT PRIVATE KEY"
+
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptSaltSz = 16
+)
+
+// KeySecrets unlock protected private keys. Either may be nil when that kind
+// of key isn't expected.
+type KeySecrets struct {
+	// Passphrase returns the passphrase of an encrypted key
+	Passphrase func() ([]byte, error)
+
+	// SplitKey returns the key a split key is sealed under. It is given the
+	// number of shares needed and a check that a recombined key opens it.
+	SplitKey func(threshold int, opens func(wrapKey []byte) bool) ([]byte, error)
+}
+
+type PassphraseRequiredError struct {
+	path string
+}
+
+type IncorrectPassphraseError struct {
+	path string
+}
+
+type UnknownKDFError struct {
+	kdf string
+}
+
+func (e *PassphraseRequiredError) Error() string {
+	return fmt.Sprintf("private key in %s is protected and no way to unlock it was given", e.path)
+}
+
+func (e *IncorrectPassphraseError) Error() string {
+	return fmt.Sprintf("incorrect passphrase or shares for private key in %s", e.path)
+}
+
+func (e *UnknownKDFError) Error() string {
+	return fmt.Sprintf("unknown key derivation function %q", e.kdf)
+}
+
+func deriveKey(passphrase, salt []byte, n, r, p int) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, n, r, p, chacha20poly1305.KeySize)
+}
+
+// sealKey encodes key as a PEM block of pemType, sealed under wrapKey
+func sealKey(buf *bytes.Buffer, pemType string, key crypto.Signer, wrapKey []byte, headers map[string]string) error {
+	keybytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	aead, err := chacha20poly1305.NewX(wrapKey)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+
+	headers["Nonce"] = EncodeB64(nonce)
+
+	return pem.Encode(buf, &pem.Block{
+		Type:    pemType,
+		Headers: headers,
+		Bytes:   aead.Seal(nil, nonce, keybytes, []byte(pemType)),
+	})
+}
+
+// openKey returns the PKCS#8 key sealed in block, or false when wrapKey
+// doesn't open it
+func openKey(block *pem.Block, wrapKey []byte) ([]byte, bool) {
+	nonce, err := DecodeB64(block.Headers["Nonce"])
+	if err != nil {
+		return nil, false
+	}
+
+	aead, err := chacha20poly1305.NewX(wrapKey)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, false
+	}
+
+	keybytes, err := aead.Open(nil, nonce, block.Bytes, []byte(block.Type))
+	if err != nil {
+		return nil, false
+	}
+
+	return keybytes, true
+}
+
+// EncodeEncryptedPrivateKey is EncodePrivateKey, but encrypts the key with
+// passphrase
+func EncodeEncryptedPrivateKey(buf *bytes.Buffer, key crypto.Signer, passphrase []byte) error {
+	salt := make([]byte, scryptSaltSz)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	wrapKey, err := deriveKey(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return err
+	}
+
+	return sealKey(buf, EncryptedKeyType, key, wrapKey, map[string]string{
+		"KDF":  "scrypt",
+		"Salt": EncodeB64(salt),
+		"N":    strconv.Itoa(scryptN),
+		"R":    strconv.Itoa(scryptR),
+		"P":    strconv.Itoa(scryptP),
+	})
+}
+
+// EncodeSplitPrivateKey is EncodePrivateKey, but seals the key under
+// wrapKey, which has been split into shares that threshold of are needed to
+// recover it
+func EncodeSplitPrivateKey(buf *bytes.Buffer, key crypto.Signer, wrapKey []byte, threshold int) error {
+	return sealKey(buf, SplitKeyType, key, wrapKey, map[string]string{
+		"Threshold": strconv.Itoa(threshold),
+	})
+}
+
+func decryptBlock(path string, block *pem.Block, passphrase []byte) ([]byte, error) {
+	if kdf := block.Headers["KDF"]; kdf != "scrypt" {
+		return nil, &UnknownKDFError{kdf: kdf}
+	}
+
+	salt, err := DecodeB64(block.Headers["Salt"])
+	if err != nil {
+		return nil, err
+	}
+
+	var params [3]int
+	for i, h := range []string{"N", "R", "P"} {
+		params[i], err = strconv.Atoi(block.Headers[h])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wrapKey, err := deriveKey(passphrase, salt, params[0], params[1], params[2])
+	if err != nil {
+		return nil, err
+	}
+
+	keybytes, ok := openKey(block, wrapKey)
+	if !ok {
+		return nil, &IncorrectPassphraseError{path: path}
+	}
+
+	return keybytes, nil
+}
+
+// unlockBlock returns the DER encoded key in block, unlocking it with secrets
+// when it is protected
+func unlockBlock(path string, block *pem.Block, secrets *KeySecrets) ([]byte, error) {
+	passphrase := func() ([]byte, error) {
+		if secrets == nil || secrets.Passphrase == nil {
+			return nil, &PassphraseRequiredError{path: path}
+		}
+
+		return secrets.Passphrase()
+	}
+
+	switch {
+	case block.Type == EncryptedKeyType:
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		return decryptBlock(path, block, pass)
+	case block.Type == EncryptedPKCS8Type:
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		return decryptPKCS8(path, block.Bytes, pass)
+	case x509.IsEncryptedPEMBlock(block):
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		return decryptLegacyBlock(path, block, pass)
+	case block.Type == SplitKeyType:
+		if secrets == nil || secrets.SplitKey == nil {
+			return nil, &PassphraseRequiredError{path: path}
+		}
+
+		threshold, err := strconv.Atoi(block.Headers["Threshold"])
+		if err != nil {
+			return nil, err
+		}
+
+		opens := func(wrapKey []byte) bool {
+			_, ok := openKey(block, wrapKey)
+			return ok
+		}
+
+		wrapKey, err := secrets.SplitKey(threshold, opens)
+		if err != nil {
+			return nil, err
+		}
+
+		keybytes, ok := openKey(block, wrapKey)
+		if !ok {
+			return nil, &IncorrectPassphraseError{path: path}
+		}
+
+		return keybytes, nil
+	default:
+		return block.Bytes, nil
+	}
+}
@@ -0,0 +1,206 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// EncryptedPKCS8Type is the PEM type of a PKCS#8 private key encrypted with a
+// passphrase, as written by openssl genpkey or openssl pkcs8 -topk8
+const EncryptedPKCS8Type = "ENCRYPTED PRIVATE KEY"
+
+type UnsupportedKeyEncryptionError struct {
+	path   string
+	scheme string
+}
+
+func (e *UnsupportedKeyEncryptionError) Error() string {
+	return fmt.Sprintf("private key in %s is encrypted with %s, which zcert can't decrypt. decrypt it with openssl pkey first", e.path, e.scheme)
+}
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt      []byte
+	CostN     int
+	BlockSize int
+	Parallel  int
+	KeyLength int `asn1:"optional"`
+}
+
+// pkcs8Cipher returns the block cipher and key size of a PBES2 encryption
+// scheme
+func pkcs8Cipher(oid asn1.ObjectIdentifier) (func([]byte) (cipher.Block, error), int, bool) {
+	switch {
+	case oid.Equal(oidAES128CBC):
+		return aes.NewCipher, 16, true
+	case oid.Equal(oidAES192CBC):
+		return aes.NewCipher, 24, true
+	case oid.Equal(oidAES256CBC):
+		return aes.NewCipher, 32, true
+	case oid.Equal(oidDESEDE3CBC):
+		return des.NewTripleDESCipher, 24, true
+	default:
+		return nil, 0, false
+	}
+}
+
+func pbkdf2Hash(prf asn1.ObjectIdentifier) (func() hash.Hash, bool) {
+	switch {
+	case len(prf) == 0, prf.Equal(oidHMACSHA1):
+		return sha1.New, true
+	case prf.Equal(oidHMACSHA256):
+		return sha256.New, true
+	case prf.Equal(oidHMACSHA384):
+		return sha512.New384, true
+	case prf.Equal(oidHMACSHA512):
+		return sha512.New, true
+	default:
+		return nil, false
+	}
+}
+
+// pkcs8Key derives the encryption key of a PBES2 key derivation function
+func pkcs8Key(path string, kdf pkix.AlgorithmIdentifier, passphrase []byte, size int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+
+		h, ok := pbkdf2Hash(params.PRF.Algorithm)
+		if !ok {
+			return nil, &UnsupportedKeyEncryptionError{path: path, scheme: fmt.Sprintf("PBKDF2 with PRF %s", params.PRF.Algorithm)}
+		}
+
+		return pbkdf2.Key(passphrase, params.Salt, params.IterationCount, size, h), nil
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+
+		return scrypt.Key(passphrase, params.Salt, params.CostN, params.BlockSize, params.Parallel, size)
+	default:
+		return nil, &UnsupportedKeyEncryptionError{path: path, scheme: fmt.Sprintf("key derivation function %s", kdf.Algorithm)}
+	}
+}
+
+// decryptPKCS8 decrypts an encrypted PKCS#8 key, returning the PKCS#8 DER.
+// Only PBES2 is supported, which OpenSSL uses by default.
+func decryptPKCS8(path string, der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, &UnsupportedKeyEncryptionError{path: path, scheme: fmt.Sprintf("PKCS#5 scheme %s", info.Algorithm.Algorithm)}
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+
+	newCipher, size, ok := pkcs8Cipher(params.EncryptionScheme.Algorithm)
+	if !ok {
+		return nil, &UnsupportedKeyEncryptionError{path: path, scheme: fmt.Sprintf("cipher %s", params.EncryptionScheme.Algorithm)}
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	key, err := pkcs8Key(path, params.KeyDerivationFunc, passphrase, size)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, &IncorrectPassphraseError{path: path}
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// a wrong passphrase shows as broken padding, or as garbage that isn't
+	// a key
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, &IncorrectPassphraseError{path: path}
+	}
+
+	plain = plain[:len(plain)-pad]
+	if _, err = x509.ParsePKCS8PrivateKey(plain); err != nil {
+		return nil, &IncorrectPassphraseError{path: path}
+	}
+
+	return plain, nil
+}
+
+// decryptLegacyBlock decrypts a key encrypted the way OpenSSL did before
+// PKCS#8, with Proc-Type and DEK-Info headers
+func decryptLegacyBlock(path string, block *pem.Block, passphrase []byte) ([]byte, error) {
+	// DecryptPEMBlock is deprecated as the format is weak, but older OpenSSL
+	// setups still have keys like this
+	keybytes, err := x509.DecryptPEMBlock(block, passphrase)
+	if err == x509.IncorrectPasswordError {
+		return nil, &IncorrectPassphraseError{path: path}
+	}
+
+	return keybytes, err
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Shamir's secret sharing over GF(2^8), one polynomial per byte of the secret.
+// Each share is the polynomials evaluated at some x, followed by that x.
+
+type ShareCountError struct {
+	shares    int
+	threshold int
+}
+
+type InvalidSharesError struct {
+	reason string
+}
+
+func (e *ShareCountError) Error() string {
+	return fmt.Sprintf("can't split into %d shares with a threshold of %d, need 2 <= threshold <= shares <= 255", e.shares, e.threshold)
+}
+
+func (e *InvalidSharesError) Error() string {
+	return fmt.Sprintf("invalid shares: %s", e.reason)
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial
+func gfMul(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+
+		b >>= 1
+	}
+
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a, which is a^254
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = gfMul(result, a)
+	}
+
+	return result
+}
+
+// evaluate returns the polynomial with the given coefficients, lowest first,
+// at x
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+
+	return y
+}
+
+// SplitSecret splits secret into shares, any threshold of which recover it
+func SplitSecret(secret []byte, shares, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > shares || shares > 255 {
+		return nil, &ShareCountError{shares: shares, threshold: threshold}
+	}
+
+	out := make([][]byte, shares)
+	for i := range out {
+		out[i] = make([]byte, len(secret)+1)
+		out[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for b, s := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		coefficients[0] = s
+		for i := range out {
+			out[i][b] = evaluate(coefficients, byte(i+1))
+		}
+	}
+
+	return out, nil
+}
+
+// CombineShares recovers a secret from at least threshold of its shares. Too
+// few or wrong shares give a wrong secret rather than an error.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, &InvalidSharesError{reason: "need at least 2 shares"}
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, &InvalidSharesError{reason: "share is too short"}
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, &InvalidSharesError{reason: "shares have different lengths"}
+		}
+
+		xs[i] = share[size-1]
+		if xs[i] == 0 || seen[xs[i]] {
+			return nil, &InvalidSharesError{reason: "duplicate share"}
+		}
+
+		seen[xs[i]] = true
+	}
+
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		// lagrange basis polynomial for share i, evaluated at 0
+		basis := byte(1)
+		for j := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(xs[j], gfInv(xs[j]^xs[i])))
+			}
+		}
+
+		for b := range secret {
+			secret[b] ^= gfMul(share[b], basis)
+		}
+	}
+
+	return secret, nil
+}
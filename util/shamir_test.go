@@ -0,0 +1,141 @@
+package util
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestGF(t *testing.T) {
+	// FIPS 197 section 4.2
+	if p := gfMul(0x57, 0x83); p != 0xc1 {
+		t.Fatalf("0x57 * 0x83 = %#x, expected 0xc1", p)
+	}
+
+	if p := gfMul(0x57, 0x13); p != 0xfe {
+		t.Fatalf("0x57 * 0x13 = %#x, expected 0xfe", p)
+	}
+
+	for a := 1; a < 256; a++ {
+		if p := gfMul(byte(a), gfInv(byte(a))); p != 1 {
+			t.Fatalf("%#x * its inverse = %#x", a, p)
+		}
+	}
+}
+
+// subsets returns every way to pick k of n indexes
+func subsets(n, k int) [][]int {
+	if k == 0 {
+		return [][]int{{}}
+	}
+
+	var out [][]int
+	for first := 0; first <= n-k; first++ {
+		for _, rest := range subsets(n-first-1, k-1) {
+			subset := []int{first}
+			for _, i := range rest {
+				subset = append(subset, first+1+i)
+			}
+
+			out = append(out, subset)
+		}
+	}
+
+	return out
+}
+
+func pick(shares [][]byte, indexes []int) [][]byte {
+	picked := make([][]byte, len(indexes))
+	for i, index := range indexes {
+		picked[i] = shares[index]
+	}
+
+	return picked
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		shares    int
+		threshold int
+	}{
+		{shares: 2, threshold: 2},
+		{shares: 3, threshold: 2},
+		{shares: 5, threshold: 3},
+		{shares: 6, threshold: 4},
+		{shares: 7, threshold: 7},
+	} {
+		shares, err := SplitSecret(secret, test.shares, test.threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(shares) != test.shares {
+			t.Fatalf("%d of %d: got %d shares", test.threshold, test.shares, len(shares))
+		}
+
+		for k := 2; k <= test.shares; k++ {
+			for _, subset := range subsets(test.shares, k) {
+				combined, err := CombineShares(pick(shares, subset))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if recovered := bytes.Equal(combined, secret); recovered != (k >= test.threshold) {
+					t.Fatalf("%d of %d: shares %v recovered the secret: %t", test.threshold, test.shares, subset, recovered)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitSecretCounts(t *testing.T) {
+	for _, test := range []struct {
+		shares    int
+		threshold int
+	}{
+		{shares: 3, threshold: 1},
+		{shares: 3, threshold: 0},
+		{shares: 2, threshold: 3},
+		{shares: 256, threshold: 2},
+	} {
+		var countErr *ShareCountError
+		if _, err := SplitSecret([]byte("secret"), test.shares, test.threshold); !errors.As(err, &countErr) {
+			t.Errorf("%d of %d: expected a share count error, got %v", test.threshold, test.shares, err)
+		}
+	}
+
+	if _, err := SplitSecret([]byte("secret"), 255, 2); err != nil {
+		t.Fatalf("splitting into 255 shares failed: %s", err)
+	}
+}
+
+func TestCombineSharesInvalid(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zero := append([]byte{}, shares[1]...)
+	zero[len(zero)-1] = 0
+
+	for name, invalid := range map[string][][]byte{
+		"one share":      {shares[0]},
+		"duplicate":      {shares[0], shares[0]},
+		"short":          {shares[0], shares[1][:len(shares[1])-1]},
+		"too short":      {{1}, {2}},
+		"zero x":         {shares[0], zero},
+		"no shares":      nil,
+		"different size": {shares[0], append(append([]byte{}, shares[1]...), 1)},
+	} {
+		var sharesErr *InvalidSharesError
+		if _, err := CombineShares(invalid); !errors.As(err, &sharesErr) {
+			t.Errorf("%s: expected an invalid shares error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package util
+
+import (
+	"net"
+)
+
+// ListenUnix listens on a unix socket at path. Platforms without a umask rely
+// on the permissions of the directory the socket is in.
+func ListenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
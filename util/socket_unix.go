@@ -0,0 +1,25 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package util
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+var umaskLock sync.Mutex
+
+// ListenUnix listens on a unix socket at path that only its owner can connect
+// to. The socket is created under a restrictive umask, as chmodding it after
+// Listen would leave a window where anyone could connect.
+func ListenUnix(path string) (net.Listener, error) {
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+
+	old := unix.Umask(0177)
+	defer unix.Umask(old)
+
+	return net.Listen("unix", path)
+}
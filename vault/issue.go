@@ -0,0 +1,433 @@
+package vault
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/db"
+	"github.com/stormentt/zcert/util"
+)
+
+// commaList is a list Vault accepts either as a JSON array or as a comma
+// separated string
+type commaList []string
+
+func (l *commaList) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*l = list
+		return nil
+	}
+
+	var joined string
+	if err := json.Unmarshal(b, &joined); err != nil {
+		return err
+	}
+
+	*l = nil
+	for _, item := range strings.Split(joined, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
+
+type issueRequest struct {
+	CSR               string          `json:"csr"`
+	CommonName        string          `json:"common_name"`
+	AltNames          commaList       `json:"alt_names"`
+	IPSANs            commaList       `json:"ip_sans"`
+	URISANs           commaList       `json:"uri_sans"`
+	TTL               json.RawMessage `json:"ttl"`
+	Format            string          `json:"format"`
+	PrivateKeyFormat  string          `json:"private_key_format"`
+	ExcludeCNFromSANs bool            `json:"exclude_cn_from_sans"`
+}
+
+type revokeRequest struct {
+	SerialNumber string `json:"serial_number"`
+}
+
+// addNames adds the names of a request to csr. Like Vault, the common name is
+// also added as a dns name unless the request asks not to.
+func addNames(csr *x509.CertificateRequest, req *issueRequest) error {
+	if len(csr.Subject.CommonName) == 0 {
+		csr.Subject.CommonName = req.CommonName
+	}
+
+	csr.DNSNames = append(csr.DNSNames, req.AltNames...)
+	for _, s := range req.IPSANs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return badRequest("invalid ip_sans value %q", s)
+		}
+
+		csr.IPAddresses = append(csr.IPAddresses, ip)
+	}
+
+	for _, s := range req.URISANs {
+		uri, err := url.Parse(s)
+		if err != nil {
+			return badRequest("invalid uri_sans value %q", s)
+		}
+
+		csr.URIs = append(csr.URIs, uri)
+	}
+
+	cn := csr.Subject.CommonName
+	if req.ExcludeCNFromSANs || len(cn) == 0 || strings.Contains(cn, "@") || net.ParseIP(cn) != nil {
+		return nil
+	}
+
+	for _, name := range csr.DNSNames {
+		if strings.EqualFold(name, cn) {
+			return nil
+		}
+	}
+
+	csr.DNSNames = append([]string{cn}, csr.DNSNames...)
+	return nil
+}
+
+// formatSerial formats a serial number the way Vault does, as colon
+// separated hex
+func formatSerial(serial *big.Int) string {
+	b := serial.Bytes()
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+
+	parts := make([]string, len(b))
+	for i := range b {
+		parts[i] = hex.EncodeToString(b[i : i+1])
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// parseSerial accepts serial numbers with colons or dashes between the bytes
+func parseSerial(s string) (*big.Int, bool) {
+	s = strings.NewReplacer(":", "", "-", "").Replace(s)
+	return new(big.Int).SetString(s, 16)
+}
+
+// encode returns crt in the requested format
+func encode(crt *x509.Certificate, format string) string {
+	if format == "der" {
+		return base64.StdEncoding.EncodeToString(crt.Raw)
+	}
+
+	return encodeCert(crt)
+}
+
+// generateKey creates the key for an issue request, following the role's
+// key_type and key_bits
+func generateKey(r *role) (crypto.Signer, error) {
+	switch r.KeyType {
+	case "ec":
+		switch r.KeyBits {
+		case 0, 256:
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case 384:
+			return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		}
+	case "rsa":
+		switch r.KeyBits {
+		case 0, 2048, 3072, 4096:
+			bits := r.KeyBits
+			if bits == 0 {
+				bits = 2048
+			}
+
+			return rsa.GenerateKey(rand.Reader, bits)
+		}
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	return nil, badRequest("role %s has unsupported key_type %q with key_bits %d", r.Name, r.KeyType, r.KeyBits)
+}
+
+// encodeKey PEM encodes key as PKCS#1 or SEC 1 like Vault does, or as PKCS#8
+// when asked for
+func encodeKey(key crypto.Signer, format string) (string, string, error) {
+	buf := new(bytes.Buffer)
+	var keyType string
+	var err error
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		keyType = "rsa"
+		if format != "pkcs8" {
+			err = pem.Encode(buf, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})
+			return strings.TrimSpace(buf.String()), keyType, err
+		}
+	case *ecdsa.PrivateKey:
+		keyType = "ec"
+		if format != "pkcs8" {
+			der, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return "", "", err
+			}
+
+			err = pem.Encode(buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+			return strings.TrimSpace(buf.String()), keyType, err
+		}
+	default:
+		keyType = "ed25519"
+	}
+
+	err = util.EncodePrivateKey(buf, key)
+	return strings.TrimSpace(buf.String()), keyType, err
+}
+
+// signFor signs csr under the role and returns the Vault response data
+func (s *Server) signFor(r *role, csr *x509.CertificateRequest, req *issueRequest) (gin.H, []string, error) {
+	if err := r.check(csr); err != nil {
+		return nil, nil, err
+	}
+
+	lifetime, warnings, err := r.lifetime(req.TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signed, err := s.authority.SignCSR(csr, r.params(lifetime))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crts, err := util.DecodeX509Certs(bytes.NewReader(signed))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	leaf := crts[0]
+	chain := []string{}
+	for _, crt := range s.authority.TrustChain() {
+		chain = append(chain, encode(crt, req.Format))
+	}
+
+	certificate := encode(leaf, req.Format)
+	if req.Format == "pem_bundle" {
+		certificate += "\n" + encodeCert(s.authority.Cert)
+	}
+
+	log.WithFields(log.Fields{
+		"role":   r.Name,
+		"serial": certs.SerialString(leaf.SerialNumber),
+	}).Info("signed certificate for vault client")
+
+	return gin.H{
+		"certificate":   certificate,
+		"issuing_ca":    encode(s.authority.Cert, req.Format),
+		"ca_chain":      chain,
+		"serial_number": formatSerial(leaf.SerialNumber),
+		"expiration":    leaf.NotAfter.Unix(),
+	}, warnings, nil
+}
+
+func checkFormat(req *issueRequest) error {
+	switch req.Format {
+	case "":
+		req.Format = "pem"
+	case "pem", "der", "pem_bundle":
+	default:
+		return badRequest("unknown format %q, use pem, der or pem_bundle", req.Format)
+	}
+
+	return nil
+}
+
+// sign signs a CSR sent by the client, as /pki/sign/:role
+func (s *Server) sign(c *gin.Context) {
+	r, err := s.loadRole(c.Param("role"))
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	req := &issueRequest{}
+	if err = decode(c, req); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err = checkFormat(req); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	csr, err := util.DecodeX509CSR(strings.NewReader(req.CSR))
+	if err != nil {
+		s.fail(c, badRequest("invalid csr: %s", err))
+		return
+	}
+
+	if err = s.authority.ValidateCSR(csr); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err = addNames(csr, req); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	data, warnings, err := s.signFor(r, csr, req)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	s.respond(c, data, warnings)
+}
+
+// issue generates a key and a certificate for it, as /pki/issue/:role
+func (s *Server) issue(c *gin.Context) {
+	r, err := s.loadRole(c.Param("role"))
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	req := &issueRequest{}
+	if err = decode(c, req); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err = checkFormat(req); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if len(req.CommonName) == 0 {
+		s.fail(c, badRequest("the common_name field is required"))
+		return
+	}
+
+	key, err := generateKey(r)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: req.CommonName}}
+	if err = addNames(template, req); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err = s.authority.ValidateCSR(csr); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	data, warnings, err := s.signFor(r, csr, req)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	privateKey, keyType, err := encodeKey(key, req.PrivateKeyFormat)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if req.Format == "der" {
+		block, _ := pem.Decode([]byte(privateKey))
+		privateKey = base64.StdEncoding.EncodeToString(block.Bytes)
+	}
+
+	if req.Format == "pem_bundle" {
+		data["certificate"] = privateKey + "\n" + data["certificate"].(string)
+	}
+
+	data["private_key"] = privateKey
+	data["private_key_type"] = keyType
+	s.respond(c, data, warnings)
+}
+
+// revoke revokes a certificate by serial number, as /pki/revoke
+func (s *Server) revoke(c *gin.Context) {
+	var req revokeRequest
+	if err := decode(c, &req); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	serialNumber, ok := parseSerial(req.SerialNumber)
+	if !ok {
+		s.fail(c, badRequest("invalid serial_number %q", req.SerialNumber))
+		return
+	}
+
+	serial := certs.SerialString(serialNumber)
+	record, err := db.CertBySerial(s.authority.DB, serial)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if record == nil {
+		s.fail(c, badRequest("certificate with serial %s not found", req.SerialNumber))
+		return
+	}
+
+	if !record.Revoked {
+		if err = record.Revoke(s.authority.DB, db.ReasonUnspecified); err != nil {
+			s.fail(c, err)
+			return
+		}
+
+		if record, err = db.CertBySerial(s.authority.DB, serial); err != nil {
+			s.fail(c, err)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"serial": serial,
+		}).Info("revoked certificate for vault client")
+	}
+
+	s.respond(c, gin.H{
+		"revocation_time":         record.RevokedAt.Unix(),
+		"revocation_time_rfc3339": record.RevokedAt.UTC().Format(time.RFC3339Nano),
+	}, nil)
+}
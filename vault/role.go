@@ -0,0 +1,193 @@
+package vault
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stormentt/zcert/certs"
+)
+
+// role is a Vault PKI role, the named issuance settings of vault.roles.<name>
+type role struct {
+	Name string `mapstructure:"-"`
+
+	// Profile is the zcert profile certificates are issued from
+	Profile string        `mapstructure:"profile"`
+	TTL     time.Duration `mapstructure:"ttl"`
+	MaxTTL  time.Duration `mapstructure:"max_ttl"`
+
+	AllowedDomains   []string `mapstructure:"allowed_domains"`
+	AllowBareDomains bool     `mapstructure:"allow_bare_domains"`
+	AllowSubdomains  bool     `mapstructure:"allow_subdomains"`
+	AllowAnyName     bool     `mapstructure:"allow_any_name"`
+	AllowIPSANs      bool     `mapstructure:"allow_ip_sans"`
+
+	ServerFlag bool `mapstructure:"server_flag"`
+	ClientFlag bool `mapstructure:"client_flag"`
+
+	// KeyType and KeyBits are the kind of key generated for issue requests
+	KeyType string `mapstructure:"key_type"`
+	KeyBits int    `mapstructure:"key_bits"`
+}
+
+type RoleNameError struct {
+	role string
+	name string
+}
+
+func (e *RoleNameError) Error() string {
+	return fmt.Sprintf("%s is not allowed by role %s", e.name, e.role)
+}
+
+func (e *RoleNameError) PolicyViolation() {}
+
+// loadRole reads the role called name, filling in Vault's defaults
+func (s *Server) loadRole(name string) (*role, error) {
+	conf := s.authority.Config()
+	key := "vault.roles." + name
+	if len(name) == 0 || !conf.IsSet(key) {
+		return nil, badRequest("unknown role: %s", name)
+	}
+
+	r := &role{
+		TTL:         conf.GetDuration("vault.ttl"),
+		AllowIPSANs: true,
+		ServerFlag:  true,
+		ClientFlag:  true,
+		KeyType:     "ed25519",
+	}
+
+	if err := conf.UnmarshalKey(key, r); err != nil {
+		return nil, err
+	}
+
+	r.Name = name
+	if r.MaxTTL == 0 {
+		r.MaxTTL = r.TTL
+	}
+
+	return r, nil
+}
+
+// allowsDomain reports whether the role may issue for the dns name
+func (r *role) allowsDomain(name string) bool {
+	if r.AllowAnyName {
+		return true
+	}
+
+	// a wildcard covers the subdomains of the name it's for
+	name = strings.ToLower(name)
+	wildcard := strings.HasPrefix(name, "*.")
+	name = strings.TrimPrefix(name, "*.")
+
+	for _, domain := range r.AllowedDomains {
+		domain = strings.ToLower(domain)
+		if r.AllowBareDomains && !wildcard && name == domain {
+			return true
+		}
+
+		if r.AllowSubdomains && (strings.HasSuffix(name, "."+domain) || (wildcard && name == domain)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check makes sure every name a CSR asks for is allowed by the role
+func (r *role) check(csr *x509.CertificateRequest) error {
+	if cn := csr.Subject.CommonName; len(cn) > 0 && !r.allowsDomain(cn) {
+		return &RoleNameError{role: r.Name, name: cn}
+	}
+
+	for _, name := range csr.DNSNames {
+		if !r.allowsDomain(name) {
+			return &RoleNameError{role: r.Name, name: name}
+		}
+	}
+
+	if len(csr.IPAddresses) > 0 && !r.AllowIPSANs {
+		return &RoleNameError{role: r.Name, name: csr.IPAddresses[0].String()}
+	}
+
+	if !r.AllowAnyName && len(csr.EmailAddresses) > 0 {
+		return &RoleNameError{role: r.Name, name: csr.EmailAddresses[0]}
+	}
+
+	if !r.AllowAnyName && len(csr.URIs) > 0 {
+		return &RoleNameError{role: r.Name, name: csr.URIs[0].String()}
+	}
+
+	return nil
+}
+
+// lifetime returns how long a certificate is valid for when ttl is asked
+// for, capping it at the role's max_ttl with a warning like Vault does
+func (r *role) lifetime(ttl json.RawMessage) (time.Duration, []string, error) {
+	lifetime := r.TTL
+	if len(ttl) > 0 && string(ttl) != `""` && string(ttl) != "null" {
+		var err error
+		if lifetime, err = parseTTL(ttl); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if lifetime <= 0 {
+		return 0, nil, badRequest("invalid ttl %s, the ttl has to be positive", lifetime)
+	}
+
+	if lifetime > r.MaxTTL {
+		warning := fmt.Sprintf("TTL %q is longer than permitted maxTTL %q, so maxTTL is being used", lifetime, r.MaxTTL)
+		return r.MaxTTL, []string{warning}, nil
+	}
+
+	return lifetime, nil, nil
+}
+
+// parseTTL reads a Vault duration: a number of seconds, or a duration string
+// that may also count days
+func parseTTL(raw json.RawMessage) (time.Duration, error) {
+	var seconds int64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	var ttl string
+	if err := json.Unmarshal(raw, &ttl); err != nil {
+		return 0, badRequest("invalid ttl %s", raw)
+	}
+
+	if seconds, err := strconv.ParseInt(ttl, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	if strings.HasSuffix(ttl, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(ttl, "d"), 10, 64)
+		if err != nil {
+			return 0, badRequest("invalid ttl %q", ttl)
+		}
+
+		return time.Duration(days) * time.Hour * 24, nil
+	}
+
+	lifetime, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, badRequest("invalid ttl %q", ttl)
+	}
+
+	return lifetime, nil
+}
+
+// params returns how certificates are signed under the role
+func (r *role) params(lifetime time.Duration) certs.CSRParams {
+	return certs.CSRParams{
+		Lifetime:   lifetime,
+		ClientAuth: r.ClientFlag,
+		ServerAuth: r.ServerFlag,
+		Profile:    r.Profile,
+	}
+}
@@ -0,0 +1,228 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/zcert/certs"
+	"github.com/stormentt/zcert/util"
+)
+
+// requestError is reported to the client as is, with status
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) *requestError {
+	return &requestError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+func permissionDenied() *requestError {
+	return &requestError{status: http.StatusForbidden, message: "permission denied"}
+}
+
+type MountConflictError struct {
+	mount  string
+	first  string
+	second string
+}
+
+func (e *MountConflictError) Error() string {
+	return fmt.Sprintf("certificate authorities %q and %q both serve the vault api under %s, give them different vault.mount settings", e.first, e.second, e.mount)
+}
+
+// response is the envelope Vault wraps every secret in
+type response struct {
+	RequestID     string      `json:"request_id"`
+	LeaseID       string      `json:"lease_id"`
+	Renewable     bool        `json:"renewable"`
+	LeaseDuration int         `json:"lease_duration"`
+	Data          interface{} `json:"data"`
+	WrapInfo      interface{} `json:"wrap_info"`
+	Warnings      []string    `json:"warnings"`
+	Auth          interface{} `json:"auth"`
+}
+
+// Server serves the parts of Vault's PKI secrets engine API services use to
+// get certificates, so they can be pointed at zcert instead
+type Server struct {
+	authority *certs.Authority
+}
+
+func New(a *certs.Authority) *Server {
+	return &Server{authority: a}
+}
+
+// Mount returns the path the API is served under: /v1/<vault.mount>, where
+// the mount is pki for the unnamed certificate authority and the name for
+// named ones unless configured
+func (s *Server) Mount() string {
+	mount := s.authority.Config().GetString("vault.mount")
+	if len(mount) == 0 {
+		mount = "pki"
+		if len(s.authority.Name) > 0 {
+			mount = s.authority.Name
+		}
+	}
+
+	return "/v1/" + strings.Trim(mount, "/")
+}
+
+// CheckMounts makes sure no two certificate authorities serve the API under
+// the same mount, which a vault.mount set for every authority would do
+func CheckMounts(authorities []*certs.Authority) error {
+	mounts := map[string]string{}
+	for _, a := range authorities {
+		if !a.Config().GetBool("vault.enabled") {
+			continue
+		}
+
+		mount := New(a).Mount()
+		if other, ok := mounts[mount]; ok {
+			return &MountConflictError{mount: mount, first: other, second: a.Name}
+		}
+
+		mounts[mount] = a.Name
+	}
+
+	return nil
+}
+
+// Routes adds the API to r
+func (s *Server) Routes(r *gin.Engine) {
+	group := r.Group(s.Mount())
+	group.GET("/ca", s.caDER)
+	group.GET("/ca/pem", s.caPEM)
+	group.GET("/ca_chain", s.caChain)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		group.Handle(method, "/sign/:role", s.requireToken("vault.token"), s.sign)
+		group.Handle(method, "/issue/:role", s.requireToken("vault.token"), s.issue)
+		group.Handle(method, "/revoke", s.requireToken("vault.admin_token"), s.revoke)
+	}
+}
+
+// requestID returns a random UUID to tag a response with, like Vault does
+func requestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (s *Server) respond(c *gin.Context, data interface{}, warnings []string) {
+	c.JSON(http.StatusOK, response{
+		RequestID: requestID(),
+		Data:      data,
+		Warnings:  warnings,
+	})
+}
+
+// fail responds with a Vault error. Policy violations and request errors are
+// passed on to the client, anything else is hidden.
+func (s *Server) fail(c *gin.Context, err error) {
+	var reqErr *requestError
+	var violation certs.PolicyViolation
+	if errors.As(err, &violation) {
+		reqErr = badRequest("%s", err)
+	} else if !errors.As(err, &reqErr) {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  c.Request.URL.Path,
+		}).Error("vault request failed")
+
+		reqErr = &requestError{status: http.StatusInternalServerError, message: "internal server error"}
+	}
+
+	c.JSON(reqErr.status, gin.H{"errors": []string{reqErr.message}})
+}
+
+// token returns the Vault token of the request, sent either in X-Vault-Token
+// or as a bearer token
+func token(c *gin.Context) string {
+	if t := c.GetHeader("X-Vault-Token"); len(t) > 0 {
+		return t
+	}
+
+	return strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// requireToken checks that the request's Vault token is the one configured
+// in key: vault.token for issuing, vault.admin_token for revoking. The token
+// is sent as is, so it's only accepted over TLS.
+func (s *Server) requireToken(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil {
+			s.fail(c, &requestError{status: http.StatusForbidden, message: "vault tokens are only accepted over https"})
+			c.Abort()
+			return
+		}
+
+		expected := s.authority.Config().GetString(key)
+		if len(expected) == 0 || subtle.ConstantTimeCompare([]byte(token(c)), []byte(expected)) != 1 {
+			s.fail(c, permissionDenied())
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// decode reads the request's JSON body into v
+func decode(c *gin.Context, v interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		return badRequest("unable to read request")
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	if err = json.Unmarshal(body, v); err != nil {
+		return badRequest("failed to parse JSON input: %s", err)
+	}
+
+	return nil
+}
+
+func encodeCert(crt *x509.Certificate) string {
+	buf := new(bytes.Buffer)
+	util.EncodeX509Cert(buf, crt.Raw)
+	return strings.TrimSpace(buf.String())
+}
+
+func (s *Server) caDER(c *gin.Context) {
+	c.Data(http.StatusOK, "application/pkix-cert", s.authority.Cert.Raw)
+}
+
+func (s *Server) caPEM(c *gin.Context) {
+	c.Data(http.StatusOK, "application/pem-certificate-chain", []byte(encodeCert(s.authority.Cert)+"\n"))
+}
+
+func (s *Server) caChain(c *gin.Context) {
+	var chain []string
+	for _, crt := range s.authority.TrustChain() {
+		chain = append(chain, encodeCert(crt))
+	}
+
+	c.Data(http.StatusOK, "application/pem-certificate-chain", []byte(strings.Join(chain, "\n")+"\n"))
+}